@@ -22,36 +22,102 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"path"
+	"os/signal"
+	"os/user"
+	"reflect"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/config"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 
 	vault "github.com/hashicorp/vault/api"
 )
 
+// socketRequestsTotal counts requests made to the agent over its socket.
+var socketRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "boathouse_agent",
+	Name:      "socket_requests_total",
+	Help:      "Requests to the agent socket, by path, method and status code.",
+}, []string{"path", "method", "code"})
+
+// instrumentSocket counts requests to the routes of the router.
+func instrumentSocket(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		counter := socketRequestsTotal.MustCurryWith(prometheus.Labels{"path": route})
+		promhttp.InstrumentHandlerCounter(counter, next).ServeHTTP(w, r)
+	})
+}
+
+// setSocketPermissions applies the configured mode and group of the socket.
+func setSocketPermissions(c config.SocketConfig) error {
+	mode, err := config.ParseMode(c.Mode)
+	if err != nil {
+		return fmt.Errorf("invalid socket mode: %v", err)
+	}
+
+	if err := os.Chmod(c.Path, mode); err != nil {
+		return err
+	}
+
+	group := c.Group
+	if group == "" {
+		return nil
+	}
+
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+
+	return os.Chown(c.Path, -1, gid)
+}
+
+func toUint32s(vals []int) []uint32 {
+	res := make([]uint32, 0, len(vals))
+	for _, val := range vals {
+		res = append(res, uint32(val))
+	}
+
+	return res
+}
+
 // agentCmd represents the agent command
 var agentCmd = &cobra.Command{
 	Use:   "agent",
 	Short: "Runs the boathouse agent",
 	Long:  `The boathouse agent provides a proxy to obtain resources from HashiCorp Vault.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		var err error
-		var socketPath *net.UnixAddr
-
 		// Process the socket
-		if flag := cmd.Flag("socket-path"); flag != nil {
-			socketPath, err = net.ResolveUnixAddr("unix", flag.Value.String())
-			if err != nil {
-				log.Fatalf("failed to resolve unix socket: %v", err)
-			}
+		socketPath, err := net.ResolveUnixAddr("unix", cfg.Socket.Path)
+		if err != nil {
+			log.Fatalf("failed to resolve unix socket: %v", err)
 		}
 
 		// If the socket exists, lets remove it
@@ -66,42 +132,204 @@ var agentCmd = &cobra.Command{
 		}
 		defer listener.Close()
 
+		if err := setSocketPermissions(cfg.Socket); err != nil {
+			log.Fatalf("failed to set socket permissions: %v", err)
+		}
+
+		// Only serve permitted users and groups
+		peers := &agent.PeerListener{
+			UnixListener: listener,
+			AllowedUIDs:  toUint32s(cfg.Socket.AllowedUIDs),
+			AllowedGIDs:  toUint32s(cfg.Socket.AllowedGIDs),
+		}
+
 		// Setup a webserver
 		router := mux.NewRouter()
 
 		// Agent
-		vc, err := vault.NewClient(&vault.Config{
-			Address:      os.Getenv("VAULT_ADDR"),
-			AgentAddress: os.Getenv("VAULT_AGENT_ADDR"),
-		})
-		agent, err := agent.NewAgent(vc)
+		vc, err := newVaultClient(cfg.Vault)
 		if err != nil {
 			log.Fatalf("failed to create vault client: %v", err)
 		}
 
+		agent, err := agent.NewAgent(vc, cfg.Agent)
+		if err != nil {
+			log.Fatalf("failed to create agent: %v", err)
+		}
+
 		if token := os.Getenv("VAULT_TOKEN"); token != "" {
 			vc.SetToken(token)
 		}
 
-		router.Path("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Write([]byte("hello world"))
-		})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if err := agent.Start(ctx); err != nil {
+			log.Fatalf("failed to authenticate to vault: %v", err)
+		}
+
+		prometheus.MustRegister(agent, socketRequestsTotal)
+		router.Use(instrumentSocket)
+
+		// Serve metrics
+		var metricsServer *http.Server
+		if addr := cfg.Metrics.Address; addr != "" {
+			metrics := http.NewServeMux()
+			metrics.Handle("/metrics", promhttp.Handler())
+			metricsServer = &http.Server{
+				Addr:    addr,
+				Handler: metrics,
+			}
 
-		router.Path("/issue").HandlerFunc(agent.HandleIssueCredentials)
+			go func() {
+				log.Printf("serving metrics on %s", addr)
+				if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+					log.Fatalf("failed to serve metrics: %v", err)
+				}
+			}()
+		}
+
+		agent.RegisterRoutes(router)
+
+		// Keep the access log apart from an audit log on stdout
+		accessLog := os.Stdout
+		if cfg.Agent.Audit.Path == "stdout" || cfg.Agent.Audit.Path == "-" {
+			accessLog = os.Stderr
+		}
 
 		server := http.Server{
-			Handler:      handlers.CombinedLoggingHandler(os.Stdout, router),
+			Handler:      handlers.CombinedLoggingHandler(accessLog, router),
 			WriteTimeout: 1 * time.Minute,
 			ReadTimeout:  15 * time.Second,
+			ConnContext:  peers.ConnContext,
 		}
 
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- server.Serve(peers)
+		}()
+
+		agent.SetListening(true)
 		log.Printf("listening on %v", socketPath)
-		log.Fatal(server.Serve(listener))
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+
+	wait:
+		for {
+			select {
+			case err := <-serveErr:
+				log.Fatalf("failed to serve: %v", err)
+			case sig := <-sigs:
+				if sig != syscall.SIGHUP {
+					log.Printf("received %v: shutting down", sig)
+					break wait
+				}
+
+				reload(agent)
+			}
+		}
+
+		// Stop accepting connections, and drain in-flight requests
+		agent.SetListening(false)
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Socket.ShutdownTimeout)
+		defer shutdownCancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("failed to drain requests: %v", err)
+			server.Close()
+		}
+
+		if err := os.Remove(socketPath.Name); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove socket: %v", err)
+		}
+
+		// Stop renewing the agent's tokens, and flush the audit log
+		cancel()
+		if err := agent.Close(); err != nil {
+			log.Printf("failed to close audit log: %v", err)
+		}
+
+		// Metrics are served until requests are drained, so they are complete
+		if metricsServer != nil {
+			if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+				metricsServer.Close()
+			}
+		}
+
+		log.Printf("shut down")
 	},
 }
 
+// newVaultClient creates a client of the default Vault target.
+func newVaultClient(c config.VaultConfig) (*vault.Client, error) {
+	vconfig := vault.DefaultConfig()
+	if c.Address != "" {
+		vconfig.Address = c.Address
+	}
+	vconfig.AgentAddress = c.AgentAddress
+
+	// Without TLS settings, keep those of Vault's environment variables
+	if c.TLS != (agent.TLSConfig{}) {
+		err := vconfig.ConfigureTLS(&vault.TLSConfig{
+			CACert:        c.TLS.CACert,
+			CAPath:        c.TLS.CAPath,
+			ClientCert:    c.TLS.ClientCert,
+			ClientKey:     c.TLS.ClientKey,
+			TLSServerName: c.TLS.ServerName,
+			Insecure:      c.TLS.Insecure,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vc, err := vault.NewClient(vconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Namespace != "" {
+		vc.SetNamespace(c.Namespace)
+	}
+
+	return vc, nil
+}
+
+// reload re-reads the configuration file, and applies the agent's
+// settings. Settings of the socket, Vault and metrics listeners
+// require a restart.
+func reload(a *agent.Agent) {
+	log.Printf("reloading configuration")
+
+	c, err := reloadConfig()
+	if err != nil {
+		log.Printf("failed to reload configuration, keeping the current configuration: %v", err)
+		return
+	}
+
+	if err := a.Reload(c.Agent); err != nil {
+		log.Printf("failed to reload agent configuration, keeping the current configuration: %v", err)
+		return
+	}
+
+	if !reflect.DeepEqual(c.Vault, cfg.Vault) || !reflect.DeepEqual(c.Socket, cfg.Socket) || c.Metrics != cfg.Metrics {
+		log.Printf("changes to the vault, socket and metrics settings require a restart: ignoring them")
+		c.Vault, c.Socket, c.Metrics = cfg.Vault, cfg.Socket, cfg.Metrics
+	}
+
+	cfg = c
+	log.Printf("reloaded configuration")
+}
+
 func init() {
 	rootCmd.AddCommand(agentCmd)
 
-	agentCmd.Flags().StringP("socket-path", "s", path.Join(os.TempDir(), "boathouse.sock"), "Listen address for agent communication.")
+	agentCmd.PersistentFlags().StringP("socket-path", "s", config.DefaultSocketPath, "Listen address for agent communication.")
+	agentCmd.Flags().String("socket-mode", "0660", "File mode of the agent socket.")
+	agentCmd.Flags().String("socket-group", "", "Group owning the agent socket, by name or ID.")
+	agentCmd.Flags().IntSlice("allowed-uids", []int{0}, "Users permitted to connect to the agent socket.")
+	agentCmd.Flags().IntSlice("allowed-gids", []int{}, "Groups permitted to connect to the agent socket.")
+	agentCmd.Flags().Duration("shutdown-timeout", 30*time.Second, "Time allowed for in-flight requests to complete on shutdown.")
+	agentCmd.Flags().String("metrics-address", ":9090", "Listen address for Prometheus metrics. Empty to disable.")
 }
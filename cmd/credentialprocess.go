@@ -0,0 +1,50 @@
+/*
+Copyright © 2020 Her Majesty the Queen in Right of Canada, as represented by the Minister of Statistics Canada
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/StatCan/boathouse/internal/mounter"
+	"github.com/spf13/cobra"
+)
+
+// credentialProcessCmd represents the credential-process command
+var credentialProcessCmd = &cobra.Command{
+	Use:    "credential-process [file]",
+	Short:  "Prints the keys of a mount as an AWS credential process",
+	Long:   `Prints the keys written for a mount, in the output format of an AWS credential process. It is run by backends using the AWS SDK (e.g., rclone), so that they read rotated keys without remounting.`,
+	Args:   cobra.ExactArgs(1),
+	Hidden: true,
+	// The keys are read from the file alone, without the configuration
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := mounter.WriteProcessCredentials(os.Stdout, args[0]); err != nil {
+			log.Fatalf("failed to read credentials: %v", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(credentialProcessCmd)
+}
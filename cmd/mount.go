@@ -30,7 +30,6 @@ import (
 	"log"
 	"net"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path"
 	"strconv"
@@ -38,47 +37,125 @@ import (
 	"time"
 
 	"github.com/StatCan/boathouse/internal/agent"
-	"github.com/StatCan/boathouse/internal/client"
+	"github.com/StatCan/boathouse/internal/config"
 	"github.com/StatCan/boathouse/internal/flexvol"
+	"github.com/StatCan/boathouse/internal/mounter"
 	"github.com/StatCan/boathouse/internal/utils"
+	"github.com/StatCan/boathouse/pkg/client"
 	"github.com/sevlyar/go-daemon"
 	"github.com/spf13/cobra"
-	"gopkg.in/ini.v1"
 	"k8s.io/klog"
 )
 
-// doCredentials requests credentials and writes them to the sepecified file.
-func doCredentials(issuer *client.Client, vaultPath string, vaultTTL time.Duration, filename string) (*agent.IssueCredentialResponse, error) {
-	creds, err := issuer.IssueCredentials(agent.IssueCredentialRequest{
-		Path: vaultPath,
-		TTL:  vaultTTL,
-	})
-	if err != nil {
-		return nil, err
+// issueCredentials requests credentials for the backend.
+func issueCredentials(ctx context.Context, issuer *client.Client, m mounter.Mounter, req agent.IssueCredentialRequest) (*agent.IssueCredentialResponse, error) {
+	if requester, ok := m.(mounter.Requester); ok {
+		if err := requester.PrepareRequest(&req); err != nil {
+			return nil, err
+		}
 	}
 
-	// Write credentials to file
-	ini.PrettyFormat = false
-	cfg := ini.Empty()
-	cfgsec, err := cfg.NewSection("default")
+	return issuer.IssueCredentials(ctx, req)
+}
+
+// doCredentials requests credentials and writes them using the backend.
+func doCredentials(ctx context.Context, issuer *client.Client, m mounter.Mounter, req agent.IssueCredentialRequest) (*agent.IssueCredentialResponse, error) {
+	creds, err := issueCredentials(ctx, issuer, m, req)
 	if err != nil {
 		return nil, err
 	}
-	if _, err = cfgsec.NewKey("aws_access_key_id", creds.AccessKey); err != nil {
+
+	if err = m.WriteCredentials(creds); err != nil {
 		return nil, err
 	}
-	if _, err = cfgsec.NewKey("aws_secret_access_key", creds.SecretKey); err != nil {
+
+	return creds, nil
+}
+
+// renewCredentials renews the lease of the credentials, and
+// rewrites them using the backend with the renewed expiry.
+func renewCredentials(ctx context.Context, issuer *client.Client, m mounter.Mounter, req agent.IssueCredentialRequest, creds *agent.IssueCredentialResponse) (*agent.Lease, error) {
+	resp, err := issuer.RenewLease(ctx, agent.RenewLeaseRequest{
+		LeaseID:     creds.Lease.ID,
+		Increment:   req.TTL,
+		Target:      creds.Lease.Target,
+		PodIdentity: req.PodIdentity,
+	})
+	if err != nil {
 		return nil, err
 	}
-	if _, err = cfgsec.NewKey("expires_at", creds.Lease.Expiry.Format(time.RFC3339)); err != nil {
+
+	renewed := *creds
+	renewed.Lease = resp.Lease
+	if err = m.WriteCredentials(&renewed); err != nil {
 		return nil, err
 	}
 
-	if err = cfg.SaveTo(filename); err != nil {
-		return nil, err
+	klog.Infof("renewed lease %s, expiring at %v", resp.Lease.ID, resp.Lease.Expiry)
+	return &resp.Lease, nil
+}
+
+// revokeCredentials revokes the lease of the credentials.
+// Static secrets have no lease to revoke.
+func revokeCredentials(ctx context.Context, issuer *client.Client, req agent.IssueCredentialRequest, creds *agent.IssueCredentialResponse) {
+	if creds.Lease.ID == "" || creds.Lease.Static {
+		return
 	}
 
-	return creds, nil
+	err := issuer.RevokeLease(ctx, agent.RevokeLeaseRequest{
+		LeaseID:     creds.Lease.ID,
+		Target:      creds.Lease.Target,
+		PodIdentity: req.PodIdentity,
+	})
+	if err != nil {
+		klog.Warningf("failed to revoke lease %s: %v", creds.Lease.ID, err)
+		return
+	}
+
+	klog.Infof("revoked lease %s", creds.Lease.ID)
+}
+
+// sameCredentials reports whether the credentials, and the version of
+// their secret, are unchanged. Their leases are not compared.
+func sameCredentials(a, b *agent.IssueCredentialResponse) bool {
+	x, y := *a, *b
+	x.Lease, y.Lease = agent.Lease{}, agent.Lease{}
+
+	return x == y
+}
+
+// maxRotationMargin is the furthest ahead of expiry that credentials are rotated.
+const maxRotationMargin = 5 * time.Minute
+
+// rotationTime returns when credentials should be rotated. Rotation happens
+// ahead of expiry, so the backend never holds expired credentials.
+func rotationTime(lease agent.Lease) time.Time {
+	margin := time.Until(lease.Expiry) / 10
+	if margin > maxRotationMargin {
+		margin = maxRotationMargin
+	}
+
+	return lease.Expiry.Add(-margin)
+}
+
+// waitForReady polls the backend until the mount is ready or the timeout elapses.
+func waitForReady(m mounter.Mounter, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ready, err := m.Ready()
+		if ready {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("mount not ready after %v: %v", timeout, err)
+			}
+			return fmt.Errorf("mount not ready after %v", timeout)
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
 }
 
 // mountCmd represents the mount command
@@ -89,7 +166,6 @@ var mountCmd = &cobra.Command{
 	// ValidArgs: []string{"mountdir", "options"},
 	Run: func(cmd *cobra.Command, args []string) {
 		var err error
-		var socketPath *net.UnixAddr
 
 		target := args[0]
 
@@ -109,18 +185,35 @@ var mountCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		if flag := cmd.Flag("agent-socket-path"); flag != nil {
-			socketPath, err = net.ResolveUnixAddr("unix", flag.Value.String())
+		// Configured defaults, when not set by the volume
+		if _, ok := options["driver"]; !ok {
+			if _, ok := options["backend"]; !ok {
+				options["backend"] = cfg.Backend.Default
+			}
+		}
+
+		if _, ok := options["dirMode"]; !ok {
+			options["dirMode"] = cfg.Backend.DirMode
+		}
+
+		if _, ok := options["fileMode"]; !ok {
+			options["fileMode"] = cfg.Backend.FileMode
+		}
+
+		if _, ok := options["knownHosts"]; !ok && cfg.Backend.SSHKnownHosts != "" {
+			options["knownHosts"] = cfg.Backend.SSHKnownHosts
+		}
+
+		socketPath, err := net.ResolveUnixAddr("unix", cfg.Socket.Path)
+		if err != nil {
+			err := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
+				Status:  flexvol.StatusFailure,
+				Message: fmt.Sprintf("failed to resolve socket: %v", err),
+			})
 			if err != nil {
-				err := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
-					Status:  flexvol.StatusFailure,
-					Message: fmt.Sprintf("failed to resolve socket: %v", err),
-				})
-				if err != nil {
-					log.Fatal(err)
-				}
-				os.Exit(1)
+				log.Fatal(err)
 			}
+			os.Exit(1)
 		}
 
 		c, err := client.NewClient(socketPath)
@@ -157,9 +250,73 @@ var mountCmd = &cobra.Command{
 			}
 		}
 
+		request := agent.IssueCredentialRequest{
+			Path: vaultPath,
+			TTL:  vaultTTL,
+
+			// Pod identity, used by the agent to authorize the request
+			PodIdentity: agent.PodIdentity{
+				Namespace:      options["kubernetes.io/pod.namespace"],
+				PodName:        options["kubernetes.io/pod.name"],
+				PodUID:         options["kubernetes.io/pod.uid"],
+				ServiceAccount: options["kubernetes.io/serviceAccount.name"],
+				MountPath:      target,
+			},
+		}
+
+		// Credential provider, or Vault cluster or namespace, when not selected by path
+		if val, ok := options["vault-target"]; ok {
+			request.Target = val
+		}
+		if val, ok := options["provider"]; ok {
+			request.Target = val
+		}
+
+		// Static secrets
+		if val, ok := options["vault-engine"]; ok {
+			request.Engine = val
+		}
+
+		if val, ok := options["vault-secret-version"]; ok {
+			ival, err := strconv.Atoi(val)
+			if err != nil {
+				err := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
+					Status:  flexvol.StatusFailure,
+					Message: fmt.Sprintf("failed to parse vault-secret-version: %v", err),
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+				os.Exit(1)
+			} else {
+				request.Version = ival
+			}
+		}
+
+		// 1. Setup the backend
+		statePrefix := path.Join(cfg.StateDir, utils.PathSum256(target))
+
+		m, err := mounter.New(mounter.Backend(options), mounter.Config{
+			Target:      target,
+			Options:     options,
+			StatePrefix: statePrefix,
+		})
+		if err == nil {
+			err = m.Validate()
+		}
+		if err != nil {
+			err := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
+				Status:  flexvol.StatusFailure,
+				Message: fmt.Sprintf("invalid backend configuration: %v", err),
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(1)
+		}
+
 		// 2. Request credentials from the agent
-		credsfile := path.Join(os.TempDir(), fmt.Sprintf("%s.creds", utils.PathSum256(target)))
-		creds, err := doCredentials(c, vaultPath, vaultTTL, credsfile)
+		creds, err := doCredentials(ctx, c, m, request)
 		if err != nil {
 			err := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
 				Status:  flexvol.StatusFailure,
@@ -171,9 +328,8 @@ var mountCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// 1. Fork(ish)!
-		// @TODO: Don't print success from parent until we know the child is good
-		pidfile := path.Join(os.TempDir(), fmt.Sprintf("%s.pid", utils.PathSum256(target)))
+		// 3. Fork(ish)!
+		pidfile := fmt.Sprintf("%s.pid", statePrefix)
 
 		dctx := new(daemon.Context)
 		child, err := dctx.Reborn()
@@ -188,10 +344,10 @@ var mountCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		// 4a. [Client] On success, signal parent that we have successfully started and write pid to state file
+		// 4a. [Client] Start the backend and write pid to state file
 		// 4ai: [Client] Timeout until credentials expire. On expiry, request new credentials
-		// 4b. [Client] On failure, signal parent that we have not started. Exit.
-		// 5. [Parent] Exits and returns success/failure based on signal
+		// 4b. [Parent] Wait for the mount to become ready. On failure, terminate the client.
+		// 5. [Parent] Exits and returns success/failure based on readiness
 		if child != nil {
 			// Write out the pid file
 			err = ioutil.WriteFile(pidfile, []byte(strconv.Itoa(child.Pid)), 0644)
@@ -199,7 +355,30 @@ var mountCmd = &cobra.Command{
 				log.Fatalf("Error writing pid file: %v", err)
 			}
 
-			err := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
+			// The client issues its own credentials, so those issued to
+			// the parent are no longer needed. When the agent shared the
+			// same lease with the client, the client holds it in its place.
+			err = waitForReady(m, cfg.Driver.MountTimeout)
+			if state, serr := mounter.LoadState(statePrefix); serr != nil || state.LeaseID != creds.Lease.ID {
+				revokeCredentials(ctx, c, request, creds)
+			}
+
+			if err != nil {
+				_ = child.Signal(syscall.SIGTERM)
+				_ = os.Remove(pidfile)
+				_ = mounter.RemoveState(statePrefix)
+
+				err := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
+					Status:  flexvol.StatusFailure,
+					Message: fmt.Sprintf("failed to mount: %v", err),
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+				os.Exit(1)
+			}
+
+			err = utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
 				Status:  flexvol.StatusSuccess,
 				Message: fmt.Sprintf("Started disk mount: %d", child.Pid),
 			})
@@ -211,75 +390,21 @@ var mountCmd = &cobra.Command{
 			defer dctx.Release()
 		}
 
-		// 3. [Client] Start goofys
-		goofysArgs := []string{}
-
-		// Run in foreground mode
-		goofysArgs = append(goofysArgs, "-f")
-
-		// File/Directory modes
-		dirMode := "0755"
-		if val, ok := options["dirMode"]; ok {
-			dirMode = val
-		}
-
-		fileMode := "0644"
-		if val, ok := options["fileMode"]; ok {
-			fileMode = val
-		}
-
-		goofysArgs = append(goofysArgs,
-			"-o", "allow_other",
-			"--dir-mode", dirMode,
-			"--file-mode", fileMode,
-		)
-
-		// Endpoint
-		if val, ok := options["endpoint"]; ok {
-			goofysArgs = append(goofysArgs, "--endpoint", val)
-		}
-
-		// Region
-		if val, ok := options["region"]; ok {
-			goofysArgs = append(goofysArgs, "--region", val)
-		}
-
-		// UID
-		if val, ok := options["uid"]; ok {
-			goofysArgs = append(goofysArgs, "--uid", val)
-		}
-
-		// GID
-		if val, ok := options["gid"]; ok {
-			goofysArgs = append(goofysArgs, "--gid", val)
+		state := mounter.State{
+			Backend:     mounter.Backend(options),
+			LeaseID:     creds.Lease.ID,
+			LeaseTarget: creds.Lease.Target,
+			Pod:         request.PodIdentity,
 		}
-
-		// Debug
-		if val, ok := options["debug_s3"]; ok {
-			bval, err := strconv.ParseBool(val)
-			if err != nil {
-				klog.Warningf("failed to parse bool for debug_s3: %s : %v", val, err)
-			}
-			if bval {
-				goofysArgs = append(goofysArgs, "--debug_s3")
-			}
+		if err := mounter.SaveState(statePrefix, state); err != nil {
+			klog.Errorf("failed to write state file: %v", err)
 		}
 
-		// Credentials
-		goofysArgs = append(goofysArgs, "--cred-filename", credsfile)
-
-		// Bucket (positional argument)
-		if val, ok := options["bucket"]; ok {
-			goofysArgs = append(goofysArgs, val)
-		} else {
-			klog.Fatalf("bucket option is required")
+		backend, err := m.Command(ctx)
+		if err != nil {
+			klog.Fatalf("failed to build backend command: %v", err)
 		}
 
-		// Mount path (positional argument)
-		goofysArgs = append(goofysArgs, target)
-
-		goofys := exec.CommandContext(ctx, "goofys", goofysArgs...)
-
 		var stdout io.Writer
 		var stderr io.Writer
 
@@ -304,14 +429,17 @@ var mountCmd = &cobra.Command{
 		klog.Infof("out file: %s", fmt.Sprintf("%s.stdout", pidfile))
 		klog.Infof("err file: %s", fmt.Sprintf("%s.stderr", pidfile))
 
-		goofys.Stdout = stdout
-		goofys.Stderr = stderr
+		backend.Stdout = stdout
+		backend.Stderr = stderr
 
-		klog.Infof("starting goofys")
-		go goofys.Run()
+		klog.Infof("starting %s", mounter.Backend(options))
+		go func() {
+			if err := backend.Run(); err != nil {
+				klog.Warningf("backend exited: %v", err)
+			}
+		}()
 
 		sigs := make(chan os.Signal, 1)
-		done := make(chan bool, 1)
 
 		// Create a context we can cancel when we terminate.
 		cctx, cancel := context.WithCancel(ctx)
@@ -319,44 +447,110 @@ var mountCmd = &cobra.Command{
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 		go func() {
 			<-sigs
-			if goofys.Process != nil {
-				goofys.Process.Signal(syscall.SIGTERM)
-			}
 			cancel()
-			done <- true
 		}()
 
-		wake := creds.Lease.Expiry
-	GoofysLoop:
+		wake := rotationTime(creds.Lease)
+	MountLoop:
 		for {
 			// Setup a new context, with the existing context as a parent,
-			// which will automatically terminate goofys when our
+			// which will automatically wake us when our
 			// credentials expire
-			credscontext, _ := context.WithDeadline(cctx, wake)
+			credscontext, credscancel := context.WithDeadline(cctx, wake)
 
 			<-credscontext.Done()
+			credscancel()
 			switch credscontext.Err() {
 			case context.DeadlineExceeded:
-				klog.Warningf("issuing new credentials: credentials expired")
-				creds, err = doCredentials(c, vaultPath, vaultTTL, credsfile)
+				if creds.Lease.Renewable {
+					lease, err := renewCredentials(ctx, c, m, request, creds)
+					if err == nil {
+						creds.Lease = *lease
+						wake = rotationTime(creds.Lease)
+						continue
+					}
+
+					klog.Warningf("failed to renew lease %s: %v", creds.Lease.ID, err)
+				}
+
+				klog.Warningf("issuing new credentials: credentials expiring")
+				next, err := issueCredentials(ctx, c, m, request)
 				if err != nil {
 					wake = time.Now().Add(time.Second * 10)
 					klog.Warningf("failed to get credentials: %v", err)
+					continue
+				}
+
+				// Unchanged credentials (e.g., a static secret which is
+				// read again) are not rewritten, and the backend is left as is
+				if sameCredentials(creds, next) {
+					klog.V(1).Infof("credentials are unchanged")
 				} else {
-					wake = creds.Lease.Expiry
+					if next.Version != creds.Version {
+						klog.Infof("picked up secret version %d", next.Version)
+					}
+
+					if err := m.WriteCredentials(next); err != nil {
+						klog.Warningf("failed to write credentials: %v", err)
+						if next.Lease.ID != creds.Lease.ID {
+							revokeCredentials(ctx, c, request, next)
+						}
+						wake = time.Now().Add(time.Second * 10)
+						continue
+					}
+
+					if reloader, ok := m.(mounter.Reloader); ok {
+						if err := reloader.Reload(backend.Process); err != nil {
+							klog.Errorf("failed to reload backend: %v", err)
+
+							// The backend still uses the previous credentials,
+							// which are kept until they expire
+							if time.Now().Before(creds.Lease.Expiry) {
+								if next.Lease.ID != creds.Lease.ID {
+									revokeCredentials(ctx, c, request, next)
+								}
+								wake = creds.Lease.Expiry
+								continue
+							}
+						}
+					}
+				}
+
+				// The previous lease is no longer used by the backend
+				if next.Lease.ID != creds.Lease.ID {
+					revokeCredentials(ctx, c, request, creds)
+				}
+
+				creds = next
+				wake = rotationTime(creds.Lease)
+
+				state.LeaseID = creds.Lease.ID
+				state.LeaseTarget = creds.Lease.Target
+				if err := mounter.SaveState(statePrefix, state); err != nil {
+					klog.Errorf("failed to write state file: %v", err)
 				}
 			case context.Canceled:
 				klog.Warningf("terminating due to context cancellation")
-				break GoofysLoop
+				break MountLoop
 			}
 		}
 
-		<-done
+		// Revoke the credentials, so that they are not usable once unmounted.
+		// The lease is removed from the state, so that it is not revoked again.
+		revokeCredentials(ctx, c, request, creds)
+
+		state.LeaseID = ""
+		if err := mounter.SaveState(statePrefix, state); err != nil {
+			klog.Errorf("failed to write state file: %v", err)
+		}
+
+		if err := m.Unmount(backend.Process); err != nil {
+			klog.Errorf("failed to unmount: %v", err)
+		}
 
-		// Remove credential file
-		klog.Infof("removing credential file %q", credsfile)
-		if err := os.Remove(credsfile); err != nil {
-			klog.Errorf("failed to remove credential file: %v", err)
+		// Remove credential files
+		if err := m.Cleanup(); err != nil {
+			klog.Errorf("failed to clean up backend: %v", err)
 		}
 
 		klog.Infof("terminating")
@@ -366,5 +560,6 @@ var mountCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(mountCmd)
 
-	mountCmd.Flags().StringP("agent-socket-path", "a", path.Join(os.TempDir(), "boathouse.sock"), "Address to connect to the agent.")
+	mountCmd.Flags().StringP("agent-socket-path", "a", config.DefaultSocketPath, "Address to connect to the agent.")
+	mountCmd.Flags().Duration("mount-timeout", 30*time.Second, "Time to wait for the mount to become ready.")
 }
@@ -0,0 +1,103 @@
+/*
+Copyright © 2020 Her Majesty the Queen in Right of Canada, as represented by the Minister of Statistics Canada
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/StatCan/boathouse/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+// probeTimeout bounds health checks of the agent.
+const probeTimeout = 5 * time.Second
+
+// probeCmd represents the agent probe command
+var probeCmd = &cobra.Command{
+	Use:       "probe [healthz|readyz]",
+	Short:     "Checks the health of the boathouse agent",
+	Long:      `Queries the health (healthz) or readiness (readyz) of the boathouse agent over its socket, exiting non-zero if it is not healthy. Suitable for use as a Kubernetes exec probe.`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{"healthz", "readyz"},
+	Run: func(cmd *cobra.Command, args []string) {
+		check := "readyz"
+		if len(args) > 0 {
+			check = args[0]
+		}
+
+		if check != "healthz" && check != "readyz" {
+			log.Fatalf("unknown check: %s", check)
+		}
+
+		socketPath, err := net.ResolveUnixAddr("unix", cfg.Socket.Path)
+		if err != nil {
+			log.Fatalf("failed to resolve unix socket: %v", err)
+		}
+
+		c, err := client.NewClient(socketPath)
+		if err != nil {
+			log.Fatalf("failed to create boathouse client: %v", err)
+		}
+
+		// Complete within the timeout of the kubelet's probes
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+
+		probe := c.Readyz
+		if check == "healthz" {
+			probe = c.Healthz
+		}
+
+		health, err := probe(ctx)
+		if health != nil {
+			for _, hc := range health.Checks {
+				status := "ok"
+				if !hc.OK {
+					status = "failed"
+				}
+
+				if hc.Message != "" {
+					fmt.Printf("%s: %s (%s)\n", hc.Name, status, hc.Message)
+				} else {
+					fmt.Printf("%s: %s\n", hc.Name, status)
+				}
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("%s: %v\n", check, err)
+			cancel()
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s: %s\n", check, health.Status)
+	},
+}
+
+func init() {
+	agentCmd.AddCommand(probeCmd)
+}
@@ -22,10 +22,18 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"flag"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"strconv"
 
+	"github.com/StatCan/boathouse/internal/config"
+	"github.com/StatCan/boathouse/internal/flexvol"
+	"github.com/StatCan/boathouse/internal/utils"
 	"github.com/spf13/cobra"
+	"k8s.io/klog"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
@@ -33,6 +41,23 @@ import (
 
 var cfgFile string
 
+// cfg is the configuration, loaded before any command runs.
+var cfg *config.Config
+
+// configFlags maps command flags to the configuration they override.
+var configFlags = map[string]string{
+	"socket-path":       "socket.path",
+	"agent-socket-path": "socket.path",
+	"socket-mode":       "socket.mode",
+	"socket-group":      "socket.group",
+	"allowed-uids":      "socket.allowedUIDs",
+	"allowed-gids":      "socket.allowedGIDs",
+	"shutdown-timeout":  "socket.shutdownTimeout",
+	"metrics-address":   "metrics.address",
+	"mount-timeout":     "driver.mountTimeout",
+	"unmount-timeout":   "driver.unmountTimeout",
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "boathouse",
@@ -41,6 +66,22 @@ var rootCmd = &cobra.Command{
 
 It obtains storage credentials from HashiCorp Vault, and manages
 daemons responsible for interacting with storage systems. (e.g., Goofys)`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := loadConfig(cmd); err != nil {
+			if !isDriverCall(cmd) {
+				log.Fatalf("invalid configuration: %v", err)
+			}
+
+			err := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
+				Status:  flexvol.StatusFailure,
+				Message: fmt.Sprintf("invalid configuration: %v", err),
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(1)
+		}
+	},
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
@@ -56,41 +97,131 @@ func Execute() {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	config.SetDefaults(viper.GetViper())
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.boathouse.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $BOATHOUSE_CONFIG, /etc/boathouse/config.yaml or $HOME/.boathouse.yaml)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
-// initConfig reads in config file and ENV variables if set.
-func initConfig() {
+// configFile returns the configuration file to read, if any.
+func configFile() string {
 	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+		return cfgFile
+	}
+
+	if file := os.Getenv("BOATHOUSE_CONFIG"); file != "" {
+		return file
+	}
+
+	candidates := []string{"/etc/boathouse/config.yaml"}
+	if home, err := homedir.Dir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".boathouse.yaml"))
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// loadConfig reads the configuration file, environment and
+// flags of the command, and applies the logging configuration.
+func loadConfig(cmd *cobra.Command) error {
+	for name, key := range configFlags {
+		if flag := cmd.Flags().Lookup(name); flag != nil {
+			if err := viper.BindPFlag(key, flag); err != nil {
+				return err
+			}
+		}
+	}
+
+	if file := configFile(); file != "" {
+		viper.SetConfigFile(file)
+		if err := viper.ReadInConfig(); err != nil {
+			return err
+		}
+	}
+
+	c, err := config.Load(viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	if err := configureLogging(c.Logging); err != nil {
+		return err
+	}
+
+	if file := viper.ConfigFileUsed(); file != "" {
+		klog.V(1).Infof("using config file: %s", file)
+	}
+
+	cfg = c
+	return nil
+}
+
+// reloadConfig re-reads the configuration file.
+func reloadConfig() (*config.Config, error) {
+	if viper.ConfigFileUsed() != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			return nil, err
 		}
+	}
+
+	c, err := config.Load(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
 
-		// Search config in home directory with name ".boathouse" (without extension).
-		viper.AddConfigPath(home)
-		viper.SetConfigName(".boathouse")
+	if err := configureLogging(c.Logging); err != nil {
+		return nil, err
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	return c, nil
+}
+
+// klogFlags configure klog, which reads its settings from flags.
+var klogFlags *flag.FlagSet
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+// configureLogging applies the logging configuration to klog.
+func configureLogging(c config.LoggingConfig) error {
+	if klogFlags == nil {
+		klogFlags = flag.NewFlagSet("klog", flag.ContinueOnError)
+		klog.InitFlags(klogFlags)
 	}
+
+	settings := map[string]string{
+		"v":           strconv.Itoa(c.Level),
+		"logtostderr": strconv.FormatBool(c.File == ""),
+		"log_file":    c.File,
+	}
+
+	for name, value := range settings {
+		if err := klogFlags.Set(name, value); err != nil {
+			return fmt.Errorf("logging: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// isDriverCall reports whether the command is a call from the kubelet
+// to the driver, which must report failures as a DriverStatus.
+func isDriverCall(cmd *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c == agentCmd {
+			return false
+		}
+	}
+
+	return true
 }
@@ -22,19 +22,74 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path"
 	"strconv"
 	"syscall"
+	"time"
 
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/config"
 	"github.com/StatCan/boathouse/internal/flexvol"
+	"github.com/StatCan/boathouse/internal/mounter"
 	"github.com/StatCan/boathouse/internal/utils"
+	"github.com/StatCan/boathouse/pkg/client"
 	"github.com/spf13/cobra"
+	"k8s.io/klog"
 )
 
+// waitForUnmount waits for target to be unmounted,
+// and reports whether it was unmounted before the timeout.
+func waitForUnmount(target string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		// Errors other than a missing target (e.g., a disconnected
+		// FUSE mount) require the target to be unmounted
+		mounted, err := utils.IsMountPoint(target)
+		if os.IsNotExist(err) || (err == nil && !mounted) {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// revokeLease asks the agent to revoke a lease. Failures are
+// logged, as they must not prevent the volume from being unmounted.
+func revokeLease(leaseID, target string, pod agent.PodIdentity) {
+	socketPath, err := net.ResolveUnixAddr("unix", cfg.Socket.Path)
+	if err != nil {
+		klog.Warningf("failed to resolve socket: %v", err)
+		return
+	}
+
+	c, err := client.NewClient(socketPath)
+	if err != nil {
+		klog.Warningf("failed to create boathouse client: %v", err)
+		return
+	}
+
+	if err = c.RevokeLease(context.Background(), agent.RevokeLeaseRequest{
+		LeaseID:     leaseID,
+		Target:      target,
+		PodIdentity: pod,
+	}); err != nil {
+		klog.Warningf("failed to revoke lease %s: %v", leaseID, err)
+		return
+	}
+
+	klog.Infof("revoked lease %s", leaseID)
+}
+
 // unmountCmd represents the unmount command
 var unmountCmd = &cobra.Command{
 	Use:   "unmount",
@@ -42,7 +97,8 @@ var unmountCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		target := args[0]
 
-		pidfile := path.Join(os.TempDir(), fmt.Sprintf("%s.pid", utils.PathSum256(target)))
+		statePrefix := path.Join(cfg.StateDir, utils.PathSum256(target))
+		pidfile := fmt.Sprintf("%s.pid", statePrefix)
 		pidstr, err := ioutil.ReadFile(pidfile)
 		if err != nil {
 			perr := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
@@ -91,6 +147,43 @@ var unmountCmd = &cobra.Command{
 			os.Exit(0)
 		}
 
+		// 2. Wait for the daemon to unmount, otherwise unmount directly
+		if !waitForUnmount(target, cfg.Driver.UnmountTimeout) {
+			backend := mounter.DefaultBackend
+			if state, err := mounter.LoadState(statePrefix); err == nil {
+				backend = state.Backend
+			}
+
+			m, err := mounter.New(backend, mounter.Config{
+				Target:      target,
+				StatePrefix: statePrefix,
+			})
+			if err == nil {
+				err = m.Unmount(nil)
+			}
+			if err == nil {
+				// The daemon did not remove the credentials it wrote
+				if cerr := m.Cleanup(); cerr != nil {
+					klog.Warningf("failed to remove the files of %s: %v", target, cerr)
+				}
+			}
+			if err != nil {
+				perr := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
+					Status:  flexvol.StatusFailure,
+					Message: fmt.Sprintf("error unmounting %s: %v", target, err),
+				})
+				if perr != nil {
+					log.Fatal(perr)
+				}
+				os.Exit(0)
+			}
+		}
+
+		// 3. Revoke the lease, if the daemon was unable to
+		if state, err := mounter.LoadState(statePrefix); err == nil && state.LeaseID != "" {
+			revokeLease(state.LeaseID, state.LeaseTarget, state.Pod)
+		}
+
 		err = os.Remove(target)
 		if err != nil && !os.IsNotExist(err) {
 			perr := utils.PrintJSON(os.Stdout, flexvol.DriverStatus{
@@ -103,8 +196,9 @@ var unmountCmd = &cobra.Command{
 			os.Exit(0)
 		}
 
-		// Remove the pid file
+		// Remove the pid and state files
 		_ = os.Remove(pidfile)
+		_ = mounter.RemoveState(statePrefix)
 		_ = os.Remove(fmt.Sprintf("%s.stdout", pidfile))
 		_ = os.Remove(fmt.Sprintf("%s.stderr", pidfile))
 
@@ -120,4 +214,7 @@ var unmountCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(unmountCmd)
+
+	unmountCmd.Flags().StringP("agent-socket-path", "a", config.DefaultSocketPath, "Address to connect to the agent.")
+	unmountCmd.Flags().Duration("unmount-timeout", 10*time.Second, "Time to wait for the mount daemon to unmount the volume.")
 }
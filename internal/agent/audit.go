@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// Audited operations.
+const (
+	AuditIssue  = "issue"
+	AuditRenew  = "renew"
+	AuditRevoke = "revoke"
+)
+
+// AuditConfig configures the audit log.
+type AuditConfig struct {
+	// Path of the file records are appended to, or "stdout".
+	// Without a path, auditing is disabled.
+	Path string `mapstructure:"path"`
+}
+
+// AuditRecord records an operation on credentials. Records
+// identify credentials by their lease, and never hold secrets.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+
+	// Caller is the process which connected to the agent
+	Caller *PeerCredentials `json:"caller,omitempty"`
+
+	Namespace      string `json:"namespace,omitempty"`
+	PodName        string `json:"pod_name,omitempty"`
+	PodUID         string `json:"pod_uid,omitempty"`
+	ServiceAccount string `json:"service_account,omitempty"`
+
+	Target       string `json:"target,omitempty"`
+	Path         string `json:"path,omitempty"`
+	RequestedTTL int64  `json:"requested_ttl,omitempty"`
+	GrantedTTL   int64  `json:"granted_ttl,omitempty"`
+	LeaseID      string `json:"lease_id,omitempty"`
+}
+
+// auditLog appends JSON records, one per line, to the audit stream.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  *os.File
+}
+
+func newAuditLog(config AuditConfig) (*auditLog, error) {
+	l := &auditLog{}
+	if err := l.reopen(config); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// reopen switches to the configured audit stream, reopening
+// the file so that it may be rotated.
+func (l *auditLog) reopen(config AuditConfig) error {
+	var w io.Writer
+	var f *os.File
+
+	switch config.Path {
+	case "":
+	case "stdout", "-":
+		w = os.Stdout
+	default:
+		var err error
+		if f, err = os.OpenFile(config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+			return err
+		}
+		w = f
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.w, l.f = w, f
+
+	return nil
+}
+
+// record appends the record to the audit stream.
+func (l *auditLog) record(rec AuditRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		klog.Errorf("error writing audit record: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.w == nil {
+		return
+	}
+
+	if _, err := l.w.Write(append(b, '\n')); err != nil {
+		klog.Errorf("error writing audit record: %v", err)
+	}
+}
+
+// Close flushes and closes the audit stream.
+func (l *auditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f := l.f
+	l.w, l.f = nil, nil
+	if f == nil {
+		return nil
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// newAuditRecord starts a record of an operation requested by the pod.
+func newAuditRecord(ctx context.Context, operation string, pod PodIdentity, err error) AuditRecord {
+	rec := AuditRecord{
+		Time:           time.Now().UTC(),
+		Operation:      operation,
+		Result:         "success",
+		Namespace:      pod.Namespace,
+		PodName:        pod.PodName,
+		PodUID:         pod.PodUID,
+		ServiceAccount: pod.ServiceAccount,
+	}
+
+	if peer, ok := PeerFromContext(ctx); ok {
+		rec.Caller = &peer
+	}
+
+	if err != nil {
+		aerr := asError(err)
+		rec.Result = aerr.Reason
+		rec.Error = aerr.Message
+	}
+
+	return rec
+}
+
+// grantedTTL returns the remaining life of the lease, in seconds.
+func grantedTTL(lease Lease) int64 {
+	return int64(time.Until(lease.Expiry).Round(time.Second).Seconds())
+}
+
+func (a *Agent) auditIssue(ctx context.Context, req IssueCredentialRequest, creds *IssueCredentialResponse, err error) {
+	rec := newAuditRecord(ctx, AuditIssue, req.PodIdentity, err)
+	rec.Target = req.Target
+	rec.Path = req.Path
+	rec.RequestedTTL = int64(req.TTL.Seconds())
+
+	if creds != nil {
+		rec.GrantedTTL = grantedTTL(creds.Lease)
+		rec.LeaseID = creds.Lease.ID
+	}
+
+	a.audit.record(rec)
+}
+
+func (a *Agent) auditRenew(ctx context.Context, req RenewLeaseRequest, path string, resp *RenewLeaseResponse, err error) {
+	rec := newAuditRecord(ctx, AuditRenew, req.PodIdentity, err)
+	rec.Target = req.Target
+	rec.Path = path
+	rec.LeaseID = req.LeaseID
+	rec.RequestedTTL = int64(req.Increment.Seconds())
+
+	if resp != nil {
+		rec.Target = resp.Lease.Target
+		rec.GrantedTTL = grantedTTL(resp.Lease)
+	}
+
+	a.audit.record(rec)
+}
+
+func (a *Agent) auditRevoke(ctx context.Context, req RevokeLeaseRequest, path string, err error) {
+	rec := newAuditRecord(ctx, AuditRevoke, req.PodIdentity, err)
+	rec.Target = req.Target
+	rec.Path = path
+	rec.LeaseID = req.LeaseID
+
+	a.audit.record(rec)
+}
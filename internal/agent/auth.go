@@ -0,0 +1,394 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StatCan/boathouse/pkg/api"
+	vault "github.com/hashicorp/vault/api"
+	"k8s.io/klog"
+)
+
+// Supported methods for the agent to authenticate to Vault.
+const (
+	AuthMethodKubernetes = "kubernetes"
+	AuthMethodAppRole    = "approle"
+	AuthMethodToken      = "token"
+)
+
+const (
+	defaultKubernetesJWTFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	// tokenCheckInterval is how often tokens without an expiry are checked.
+	tokenCheckInterval = 5 * time.Minute
+
+	// tokenRetryInterval is how long to wait after failing to obtain a token.
+	tokenRetryInterval = 10 * time.Second
+)
+
+// AuthConfig configures how the agent authenticates to Vault.
+// Without a method, the agent uses the token from its environment
+// (VAULT_TOKEN), or relies on a Vault agent to provide one.
+type AuthConfig struct {
+	// Method is one of kubernetes, approle or token.
+	Method string `mapstructure:"method"`
+
+	// MountPath is the path of the auth method. (default: the method name)
+	MountPath string `mapstructure:"mountPath"`
+
+	// Role is the Vault role to log in with. (kubernetes)
+	Role string `mapstructure:"role"`
+
+	// JWTFile holds the agent's service account token. (kubernetes)
+	JWTFile string `mapstructure:"jwtFile"`
+
+	// RoleIDFile and SecretIDFile hold the AppRole credentials. (approle)
+	RoleIDFile   string `mapstructure:"roleIdFile"`
+	SecretIDFile string `mapstructure:"secretIdFile"`
+
+	// TokenFile holds a token, which is re-read when it expires. (token)
+	TokenFile string `mapstructure:"tokenFile"`
+}
+
+// validate checks that the settings required by the method are present.
+func (c AuthConfig) validate() error {
+	switch c.Method {
+	case "":
+	case AuthMethodKubernetes:
+		if c.Role == "" {
+			return fmt.Errorf("auth: role is required for the kubernetes method")
+		}
+	case AuthMethodAppRole:
+		if c.RoleIDFile == "" || c.SecretIDFile == "" {
+			return fmt.Errorf("auth: roleIdFile and secretIdFile are required for the approle method")
+		}
+	case AuthMethodToken:
+		if c.TokenFile == "" {
+			return fmt.Errorf("auth: tokenFile is required for the token method")
+		}
+	default:
+		return fmt.Errorf("auth: unsupported method %q", c.Method)
+	}
+
+	return nil
+}
+
+// TokenHealth reports the state of the agent's Vault token.
+type TokenHealth = api.TokenHealth
+
+// tokenManager obtains the agent's Vault token, and keeps it alive by
+// renewing it, or logging in again once it can no longer be renewed.
+type tokenManager struct {
+	config AuthConfig
+	vault  *vault.Client
+
+	// reauth is signalled when Vault refuses the token
+	reauth chan struct{}
+
+	mu     sync.Mutex
+	health TokenHealth
+}
+
+func newTokenManager(config AuthConfig, vc *vault.Client) *tokenManager {
+	if config.MountPath == "" {
+		config.MountPath = config.Method
+	}
+
+	if config.JWTFile == "" {
+		config.JWTFile = defaultKubernetesJWTFile
+	}
+
+	return &tokenManager{
+		config: config,
+		vault:  vc,
+		reauth: make(chan struct{}, 1),
+		health: TokenHealth{
+			Method: config.Method,
+		},
+	}
+}
+
+// Start obtains the initial token, and keeps it alive until ctx is done.
+func (m *tokenManager) Start(ctx context.Context) error {
+	if err := m.login(); err != nil {
+		return err
+	}
+
+	go m.run(ctx)
+	return nil
+}
+
+// Health reports the state of the token.
+func (m *tokenManager) Health() TokenHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	health := m.health
+	if !health.Expiry.IsZero() && time.Now().After(health.Expiry) {
+		health.Valid = false
+	}
+
+	return health
+}
+
+// Refused notifies the manager that Vault refused the token,
+// so that it logs in again.
+func (m *tokenManager) Refused() {
+	select {
+	case m.reauth <- struct{}{}:
+	default:
+	}
+}
+
+func (m *tokenManager) run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(m.nextRefresh())
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-m.reauth:
+			timer.Stop()
+			klog.Warningf("vault refused the agent's token: logging in again")
+			m.refresh(false)
+		case <-timer.C:
+			m.refresh(true)
+		}
+	}
+}
+
+// nextRefresh returns how long until the token should be refreshed.
+func (m *tokenManager) nextRefresh() time.Duration {
+	health := m.Health()
+
+	switch {
+	case health.Error != "":
+		return tokenRetryInterval
+	case health.Expiry.IsZero():
+		return tokenCheckInterval
+	default:
+		// Refresh two thirds of the way through the token's remaining life
+		return time.Until(health.Expiry) * 2 / 3
+	}
+}
+
+// refresh renews the token if possible, and otherwise logs in again.
+func (m *tokenManager) refresh(renew bool) {
+	if renew && m.Health().Renewable {
+		err := m.renew()
+		if err == nil {
+			return
+		}
+
+		klog.Warningf("unable to renew vault token: %v", err)
+	}
+
+	if err := m.login(); err != nil {
+		klog.Errorf("unable to log in to vault: %v", err)
+	}
+}
+
+// renew renews the token, failing if it has reached its maximum TTL.
+func (m *tokenManager) renew() error {
+	secret, err := m.vault.Auth().Token().RenewSelf(0)
+	if err != nil {
+		return err
+	}
+
+	if secret == nil || secret.Auth == nil {
+		return fmt.Errorf("failure: no token returned from vault")
+	}
+
+	expiry := time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+	if !expiry.After(m.Health().Expiry) {
+		return fmt.Errorf("token has reached its maximum TTL")
+	}
+
+	klog.Infof("renewed vault token, expiring at %v", expiry)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.health.Valid = true
+	m.health.Expiry = expiry
+	m.health.Renewable = secret.Auth.Renewable
+	m.health.LastRenewal = time.Now()
+	m.health.Error = ""
+
+	return nil
+}
+
+// login obtains a new token using the configured method.
+func (m *tokenManager) login() error {
+	var secret *vault.Secret
+	var err error
+
+	switch m.config.Method {
+	case AuthMethodKubernetes:
+		secret, err = m.loginKubernetes()
+	case AuthMethodAppRole:
+		secret, err = m.loginAppRole()
+	case AuthMethodToken:
+		secret, err = m.readTokenFile()
+	}
+
+	if err == nil && (secret == nil || secret.Auth == nil) {
+		err = fmt.Errorf("failure: no token returned from vault")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.health.Error = err.Error()
+		return err
+	}
+
+	m.vault.SetToken(secret.Auth.ClientToken)
+
+	m.health.Valid = true
+	m.health.Expiry = time.Time{}
+	if secret.Auth.LeaseDuration > 0 {
+		m.health.Expiry = time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+	}
+	m.health.Renewable = secret.Auth.Renewable
+	m.health.LastRenewal = time.Now()
+	m.health.Error = ""
+
+	klog.Infof("obtained vault token using %s, expiring at %v", m.config.Method, m.health.Expiry)
+
+	return nil
+}
+
+func (m *tokenManager) loginKubernetes() (*vault.Secret, error) {
+	jwt, err := readSecretFile(m.config.JWTFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.write(fmt.Sprintf("auth/%s/login", m.config.MountPath), map[string]interface{}{
+		"role": m.config.Role,
+		"jwt":  jwt,
+	})
+}
+
+func (m *tokenManager) loginAppRole() (*vault.Secret, error) {
+	roleID, err := readSecretFile(m.config.RoleIDFile)
+	if err != nil {
+		return nil, err
+	}
+
+	secretID, err := readSecretFile(m.config.SecretIDFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.write(fmt.Sprintf("auth/%s/login", m.config.MountPath), map[string]interface{}{
+		"role_id":   roleID,
+		"secret_id": secretID,
+	})
+}
+
+// readTokenFile reads the token file, and looks up the token's lifetime.
+func (m *tokenManager) readTokenFile() (*vault.Secret, error) {
+	token, err := readSecretFile(m.config.TokenFile)
+	if err != nil {
+		return nil, err
+	}
+
+	vc, err := cloneClient(m.vault)
+	if err != nil {
+		return nil, err
+	}
+	vc.SetToken(token)
+
+	lookup, err := vc.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := lookup.TokenTTL()
+	if err != nil {
+		return nil, err
+	}
+
+	renewable, _ := lookup.TokenIsRenewable()
+
+	return &vault.Secret{
+		Auth: &vault.SecretAuth{
+			ClientToken:   token,
+			LeaseDuration: int(ttl.Seconds()),
+			Renewable:     renewable,
+		},
+	}, nil
+}
+
+// write logs in without sending the current token.
+func (m *tokenManager) write(path string, data map[string]interface{}) (*vault.Secret, error) {
+	vc, err := cloneClient(m.vault)
+	if err != nil {
+		return nil, err
+	}
+	vc.ClearToken()
+
+	return vc.Logical().Write(path, data)
+}
+
+// readSecretFile reads a file holding a single secret value.
+func readSecretFile(filename string) (string, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// isPermissionDenied reports whether Vault refused a request.
+func isPermissionDenied(err error) bool {
+	rerr, ok := err.(*vault.ResponseError)
+	return ok && rerr.StatusCode == http.StatusForbidden
+}
+
+// TokenHealth reports the state of the agent's Vault token for each target.
+// Tokens provided by the environment are looked up to check that they are valid.
+func (a *Agent) TokenHealth() []TokenHealth {
+	health := make([]TokenHealth, 0, len(a.targets))
+	for _, t := range a.targets {
+		health = append(health, t.tokenHealth())
+	}
+
+	return health
+}
+
+func (t *target) tokenHealth() TokenHealth {
+	if t.auth != nil {
+		health := t.auth.Health()
+		health.Target = t.name
+		return health
+	}
+
+	health := TokenHealth{
+		Target: t.name,
+		Method: "environment",
+	}
+
+	lookup, err := t.vault.Auth().Token().LookupSelf()
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+
+	health.Valid = true
+	if ttl, err := lookup.TokenTTL(); err == nil && ttl > 0 {
+		health.Expiry = time.Now().Add(ttl)
+	}
+	health.Renewable, _ = lookup.TokenIsRenewable()
+
+	return health
+}
@@ -0,0 +1,383 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// defaultCacheRefreshBefore is how long before expiry, by default,
+// a cached lease stops being handed out to new requesters.
+const defaultCacheRefreshBefore = 5 * time.Minute
+
+// CacheConfig configures the sharing of leases between mounts of the same path.
+type CacheConfig struct {
+	// Disabled issues new credentials for every request.
+	Disabled bool `mapstructure:"disabled"`
+
+	// RefreshBefore is how long before expiry a cached lease
+	// stops being handed out, and new credentials are issued.
+	RefreshBefore time.Duration `mapstructure:"refreshBefore"`
+
+	// ExcludePaths are Vault path prefixes whose leases are never shared.
+	ExcludePaths []string `mapstructure:"excludePaths"`
+}
+
+// cacheEntry is a cached issuance, which may still be in flight.
+type cacheEntry struct {
+	// ready is closed once the issuance completes
+	ready chan struct{}
+
+	creds *IssueCredentialResponse
+	err   error
+}
+
+// heldLease tracks the mounts holding a lease.
+type heldLease struct {
+	// holders are the mounts holding the lease, by holderKey
+	holders map[string]PodIdentity
+
+	// anonymous counts holders which did not identify their mount,
+	// as drivers of earlier releases do
+	anonymous int
+
+	expiry time.Time
+	target string
+	path   string
+}
+
+// count returns the number of mounts holding the lease.
+func (l *heldLease) count() int {
+	return len(l.holders) + l.anonymous
+}
+
+// holderKey identifies the mount of a pod, or is empty
+// if the pod did not identify its mount.
+func holderKey(pod PodIdentity) string {
+	if pod.PodUID == "" && pod.MountPath == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s|%s", pod.PodUID, pod.MountPath)
+}
+
+// leaseCache shares leases between requests for the same credentials.
+// Leases are reference counted, so that they are only revoked
+// once every mount holding them has released them.
+type leaseCache struct {
+	// perIdentity only shares leases between pods with the same
+	// identity, as when each pod logs in to Vault.
+	perIdentity bool
+
+	mu      sync.Mutex
+	config  CacheConfig
+	entries map[string]*cacheEntry
+	leases  map[string]*heldLease
+}
+
+func newLeaseCache(config CacheConfig, perIdentity bool) *leaseCache {
+	c := &leaseCache{
+		perIdentity: perIdentity,
+		entries:     map[string]*cacheEntry{},
+		leases:      map[string]*heldLease{},
+	}
+	c.reconfigure(config)
+
+	return c
+}
+
+// reconfigure applies the configuration to later requests.
+// Leases already held are unaffected.
+func (c *leaseCache) reconfigure(config CacheConfig) {
+	if config.RefreshBefore == 0 {
+		config.RefreshBefore = defaultCacheRefreshBefore
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.config = config
+}
+
+// cacheable reports whether the credentials requested may be shared.
+func (c *leaseCache) cacheable(req IssueCredentialRequest) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Signed keys are bound to the requester's key
+	if c.config.Disabled || req.PublicKey != "" {
+		return false
+	}
+
+	for _, prefix := range c.config.ExcludePaths {
+		if strings.HasPrefix(req.Path, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// get returns the cached credentials for the request, calling issue
+// when there are none. Concurrent requests share a single call to issue.
+func (c *leaseCache) get(req IssueCredentialRequest, issue func() (*IssueCredentialResponse, error)) (*IssueCredentialResponse, error) {
+	key := fmt.Sprintf("%s|%s|%s|%d|%v", req.Target, req.Engine, req.Path, req.Version, req.TTL)
+	if c.perIdentity {
+		key = fmt.Sprintf("%s/%s|%s", req.Namespace, req.ServiceAccount, key)
+	}
+
+	c.mu.Lock()
+	for {
+		entry, ok := c.entries[key]
+		if !ok {
+			break
+		}
+
+		c.mu.Unlock()
+		<-entry.ready
+		c.mu.Lock()
+
+		// Requests collapsed into a failed issuance share its error
+		if entry.err != nil {
+			c.mu.Unlock()
+			return nil, entry.err
+		}
+
+		if time.Until(entry.creds.Lease.Expiry) > c.config.RefreshBefore {
+			klog.Infof("sharing cached lease %s for %s", entry.creds.Lease.ID, req.Path)
+			creds := c.hold(req.Path, req.PodIdentity, entry.creds)
+			c.mu.Unlock()
+			return creds, nil
+		}
+
+		// Expiring: issue new credentials
+		if c.entries[key] == entry {
+			delete(c.entries, key)
+		}
+	}
+
+	entry := &cacheEntry{
+		ready: make(chan struct{}),
+	}
+	c.entries[key] = entry
+	c.mu.Unlock()
+
+	creds, err := issue()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.creds, entry.err = creds, err
+	close(entry.ready)
+
+	if err != nil {
+		delete(c.entries, key)
+		return nil, err
+	}
+
+	return c.hold(req.Path, req.PodIdentity, creds), nil
+}
+
+// hold records the pod's mount as a holder of the lease issued for path,
+// and returns a copy of the credentials. The caller must hold the lock.
+func (c *leaseCache) hold(path string, pod PodIdentity, creds *IssueCredentialResponse) *IssueCredentialResponse {
+	// Forget expired leases, which mounts may never have released
+	for id, lease := range c.leases {
+		if time.Now().After(lease.expiry) {
+			delete(c.leases, id)
+		}
+	}
+
+	if creds.Lease.ID != "" {
+		lease, ok := c.leases[creds.Lease.ID]
+		if !ok {
+			lease = &heldLease{
+				holders: map[string]PodIdentity{},
+				expiry:  creds.Lease.Expiry,
+				target:  creds.Lease.Target,
+				path:    path,
+			}
+			c.leases[creds.Lease.ID] = lease
+		}
+
+		if key := holderKey(pod); key != "" {
+			lease.holders[key] = pod
+		} else {
+			lease.anonymous++
+		}
+	}
+
+	shared := *creds
+	return &shared
+}
+
+// track records the holder of a lease issued for path without
+// the cache, so that held leases are accounted for.
+func (c *leaseCache) track(path string, pod PodIdentity, creds *IssueCredentialResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hold(path, pod, creds)
+}
+
+// list returns the unexpired leases held, by expiry.
+func (c *leaseCache) list() []HeldLease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	leases := []HeldLease{}
+	for id, lease := range c.leases {
+		if time.Now().After(lease.expiry) {
+			continue
+		}
+
+		leases = append(leases, HeldLease{
+			ID:      id,
+			Expiry:  lease.expiry,
+			Target:  lease.target,
+			Path:    lease.path,
+			Holders: lease.count(),
+		})
+	}
+
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].Expiry.Before(leases[j].Expiry)
+	})
+
+	return leases
+}
+
+// held returns the number of leases held, and the earliest of their expiries.
+func (c *leaseCache) held() (int, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var nearest time.Time
+	count := 0
+	for _, lease := range c.leases {
+		if time.Now().After(lease.expiry) {
+			continue
+		}
+
+		count++
+		if nearest.IsZero() || lease.expiry.Before(nearest) {
+			nearest = lease.expiry
+		}
+	}
+
+	return count, nearest
+}
+
+// renewed updates the expiry of cached credentials holding the lease.
+func (c *leaseCache) renewed(lease Lease) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if held, ok := c.leases[lease.ID]; ok {
+		held.expiry = lease.Expiry
+	}
+
+	for key, entry := range c.entries {
+		select {
+		case <-entry.ready:
+		default:
+			continue
+		}
+
+		if entry.err == nil && entry.creds.Lease.ID == lease.ID {
+			creds := *entry.creds
+			creds.Lease = lease
+			c.entries[key] = &cacheEntry{
+				ready: entry.ready,
+				creds: &creds,
+			}
+		}
+	}
+}
+
+// path returns the path the lease was issued for, if it is held.
+func (c *leaseCache) path(leaseID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lease, ok := c.leases[leaseID]
+	if !ok {
+		return "", false
+	}
+
+	return lease.path, true
+}
+
+// holds reports whether the lease is held, and if so,
+// whether it is held by the pod's mount.
+func (c *leaseCache) holds(leaseID string, pod PodIdentity) (holder, held bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lease, ok := c.leases[leaseID]
+	if !ok || time.Now().After(lease.expiry) {
+		return false, false
+	}
+
+	if key := holderKey(pod); key != "" {
+		_, holder = lease.holders[key]
+	} else {
+		holder = lease.anonymous > 0
+	}
+
+	return holder, true
+}
+
+// release removes the pod's mount from the holders of the lease, and
+// reports whether the lease is no longer held and may be revoked.
+// Releasing a lease the mount does not hold has no effect, so that
+// a lease released twice by a mount is not revoked under others.
+// Leases which are not held (e.g., held before the agent restarted)
+// may be revoked.
+func (c *leaseCache) release(leaseID string, pod PodIdentity) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lease, ok := c.leases[leaseID]
+	if !ok {
+		return true
+	}
+
+	if key := holderKey(pod); key != "" {
+		if _, ok := lease.holders[key]; !ok {
+			klog.Infof("lease %s is not held by %s", leaseID, key)
+			return false
+		}
+		delete(lease.holders, key)
+	} else {
+		if lease.anonymous == 0 {
+			klog.Infof("lease %s is not held by an unidentified mount", leaseID)
+			return false
+		}
+		lease.anonymous--
+	}
+
+	if lease.count() > 0 {
+		klog.Infof("lease %s is still held by %d mounts", leaseID, lease.count())
+		return false
+	}
+
+	delete(c.leases, leaseID)
+	for key, entry := range c.entries {
+		select {
+		case <-entry.ready:
+		default:
+			continue
+		}
+
+		if entry.err == nil && entry.creds.Lease.ID == leaseID {
+			delete(c.entries, key)
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testCreds(id string, ttl time.Duration) *IssueCredentialResponse {
+	return &IssueCredentialResponse{
+		Lease: Lease{
+			ID:     id,
+			Expiry: time.Now().Add(ttl),
+		},
+		AccessKey: "access",
+		SecretKey: "secret",
+	}
+}
+
+func testRequest(podUID string) IssueCredentialRequest {
+	return IssueCredentialRequest{
+		Path: "minio/keys/team-a",
+		PodIdentity: PodIdentity{
+			Namespace: "team-a",
+			PodUID:    podUID,
+			MountPath: "/var/lib/kubelet/pods/" + podUID + "/volumes/data",
+		},
+	}
+}
+
+func TestLeaseCacheCollapsesInFlightRequests(t *testing.T) {
+	c := newLeaseCache(CacheConfig{}, false)
+
+	var calls int32
+	release := make(chan struct{})
+	issue := func() (*IssueCredentialResponse, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return testCreds("minio/keys/team-a/1", time.Hour), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*IssueCredentialResponse, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			creds, err := c.get(testRequest(string(rune('a'+i))), issue)
+			if err != nil {
+				t.Errorf("get: %v", err)
+			}
+			results[i] = creds
+		}(i)
+	}
+
+	// Let the requests queue behind the first issuance
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("issue called %d times, want 1", calls)
+	}
+
+	for i, creds := range results {
+		if creds == nil || creds.Lease.ID != "minio/keys/team-a/1" {
+			t.Errorf("request %d: got %+v, want the shared lease", i, creds)
+		}
+	}
+
+	leases := c.list()
+	if len(leases) != 1 || leases[0].Holders != 3 {
+		t.Errorf("got leases %+v, want one lease with 3 holders", leases)
+	}
+}
+
+func TestLeaseCacheSharesFailures(t *testing.T) {
+	c := newLeaseCache(CacheConfig{}, false)
+
+	failure := errors.New("vault is sealed")
+	if _, err := c.get(testRequest("a"), func() (*IssueCredentialResponse, error) {
+		return nil, failure
+	}); err != failure {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+
+	// Failures are not cached
+	creds, err := c.get(testRequest("a"), func() (*IssueCredentialResponse, error) {
+		return testCreds("minio/keys/team-a/2", time.Hour), nil
+	})
+	if err != nil || creds.Lease.ID != "minio/keys/team-a/2" {
+		t.Errorf("got %+v, %v, want a new lease", creds, err)
+	}
+}
+
+func TestLeaseCacheRefreshBefore(t *testing.T) {
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		calls int32
+	}{
+		{name: "fresh lease is shared", ttl: time.Hour, calls: 1},
+		{name: "expiring lease is replaced", ttl: time.Minute, calls: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLeaseCache(CacheConfig{RefreshBefore: 5 * time.Minute}, false)
+
+			var calls int32
+			issue := func() (*IssueCredentialResponse, error) {
+				n := atomic.AddInt32(&calls, 1)
+				return testCreds("minio/keys/team-a/"+string(rune('0'+n)), tt.ttl), nil
+			}
+
+			for _, pod := range []string{"a", "b"} {
+				if _, err := c.get(testRequest(pod), issue); err != nil {
+					t.Fatalf("get: %v", err)
+				}
+			}
+
+			if calls != tt.calls {
+				t.Errorf("issue called %d times, want %d", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestLeaseCacheCacheable(t *testing.T) {
+	tests := []struct {
+		name   string
+		config CacheConfig
+		req    IssueCredentialRequest
+		want   bool
+	}{
+		{name: "shared", req: IssueCredentialRequest{Path: "minio/keys/team-a"}, want: true},
+		{name: "disabled", config: CacheConfig{Disabled: true}, req: IssueCredentialRequest{Path: "minio/keys/team-a"}},
+		{name: "excluded", config: CacheConfig{ExcludePaths: []string{"minio/keys/"}}, req: IssueCredentialRequest{Path: "minio/keys/team-a"}},
+		{name: "signed key", req: IssueCredentialRequest{Path: "ssh/sign/team-a", PublicKey: "ssh-ed25519 AAAA"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLeaseCache(tt.config, false)
+			if got := c.cacheable(tt.req); got != tt.want {
+				t.Errorf("cacheable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeaseCacheRelease(t *testing.T) {
+	const id = "minio/keys/team-a/1"
+	a := testRequest("a").PodIdentity
+	b := testRequest("b").PodIdentity
+
+	tests := []struct {
+		name    string
+		holders []PodIdentity
+		release []PodIdentity
+		want    []bool
+	}{
+		{
+			name:    "single holder",
+			holders: []PodIdentity{a},
+			release: []PodIdentity{a},
+			want:    []bool{true},
+		},
+		{
+			name:    "revoked once every holder releases",
+			holders: []PodIdentity{a, b},
+			release: []PodIdentity{a, b},
+			want:    []bool{false, true},
+		},
+		{
+			name:    "released twice by the same mount",
+			holders: []PodIdentity{a, b},
+			release: []PodIdentity{a, a, b},
+			want:    []bool{false, false, true},
+		},
+		{
+			name:    "held twice by the same mount",
+			holders: []PodIdentity{a, a},
+			release: []PodIdentity{a},
+			want:    []bool{true},
+		},
+		{
+			name:    "released by a mount not holding it",
+			holders: []PodIdentity{a},
+			release: []PodIdentity{b, a},
+			want:    []bool{false, true},
+		},
+		{
+			name:    "unidentified holders are counted",
+			holders: []PodIdentity{{}, {}},
+			release: []PodIdentity{{}, {}},
+			want:    []bool{false, true},
+		},
+		{
+			name:    "unidentified mounts do not release identified holders",
+			holders: []PodIdentity{a},
+			release: []PodIdentity{{}, a},
+			want:    []bool{false, true},
+		},
+		{
+			name:    "unknown lease",
+			release: []PodIdentity{a},
+			want:    []bool{true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newLeaseCache(CacheConfig{}, false)
+			for _, pod := range tt.holders {
+				c.track("minio/keys/team-a", pod, testCreds(id, time.Hour))
+			}
+
+			for i, pod := range tt.release {
+				if got := c.release(id, pod); got != tt.want[i] {
+					t.Errorf("release %d = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLeaseCacheReleaseForgetsSharedCredentials(t *testing.T) {
+	c := newLeaseCache(CacheConfig{}, false)
+
+	var calls int32
+	issue := func() (*IssueCredentialResponse, error) {
+		n := atomic.AddInt32(&calls, 1)
+		return testCreds("minio/keys/team-a/"+string(rune('0'+n)), time.Hour), nil
+	}
+
+	creds, err := c.get(testRequest("a"), issue)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if !c.release(creds.Lease.ID, testRequest("a").PodIdentity) {
+		t.Fatalf("release = false, want true")
+	}
+
+	// The revoked lease must not be handed out again
+	next, err := c.get(testRequest("b"), issue)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if next.Lease.ID == creds.Lease.ID {
+		t.Errorf("got revoked lease %s", next.Lease.ID)
+	}
+}
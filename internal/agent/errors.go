@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/StatCan/boathouse/internal/flexvol"
+	"github.com/StatCan/boathouse/pkg/api"
+	vault "github.com/hashicorp/vault/api"
+	"k8s.io/klog"
+)
+
+// Reasons for failed requests.
+const (
+	ReasonBadRequest       = api.ReasonBadRequest
+	ReasonPermissionDenied = api.ReasonPermissionDenied
+	ReasonNotFound         = api.ReasonNotFound
+	ReasonMethodNotAllowed = api.ReasonMethodNotAllowed
+	ReasonNotRenewable     = api.ReasonNotRenewable
+	ReasonTooLarge         = api.ReasonTooLarge
+	ReasonRateLimited      = api.ReasonRateLimited
+	ReasonUnavailable      = api.ReasonUnavailable
+	ReasonVaultError       = api.ReasonVaultError
+	ReasonInternal         = api.ReasonInternal
+)
+
+// Error is a failed request. It is returned to clients as a failed
+// DriverStatus, whose message may be passed on to the kubelet.
+type Error = api.Error
+
+func newError(code int, reason, format string, args ...interface{}) *Error {
+	return &Error{
+		Status:  string(flexvol.StatusFailure),
+		Code:    code,
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// notFound is returned when Vault has no secret at path.
+func notFound(path string) *Error {
+	observeVaultError(http.StatusNotFound)
+	return newError(http.StatusNotFound, ReasonNotFound, "no such path %s", path)
+}
+
+// vaultError converts an error from a Vault request on path into an
+// Error, with a status following that of Vault's response.
+func vaultError(err error, path string) error {
+	if err == nil {
+		return nil
+	}
+
+	rerr, ok := err.(*vault.ResponseError)
+	if !ok {
+		observeVaultError(0)
+		return newError(http.StatusServiceUnavailable, ReasonUnavailable, "unable to reach vault: %v", err)
+	}
+
+	// Not found is recorded by notFound
+	if rerr.StatusCode != http.StatusNotFound {
+		observeVaultError(rerr.StatusCode)
+	}
+
+	switch rerr.StatusCode {
+	case http.StatusBadRequest:
+		return newError(http.StatusBadRequest, ReasonBadRequest, "invalid request on %s: %s", path, strings.Join(rerr.Errors, "; "))
+	case http.StatusForbidden:
+		return newError(http.StatusForbidden, ReasonPermissionDenied, "permission denied on %s", path)
+	case http.StatusNotFound:
+		return notFound(path)
+	case http.StatusTooManyRequests:
+		return newError(http.StatusTooManyRequests, ReasonRateLimited, "rate limited by vault on %s", path)
+	case http.StatusServiceUnavailable:
+		return newError(http.StatusServiceUnavailable, ReasonUnavailable, "vault is sealed or unavailable")
+	default:
+		return newError(http.StatusBadGateway, ReasonVaultError, "vault returned %d on %s: %s", rerr.StatusCode, path, strings.Join(rerr.Errors, "; "))
+	}
+}
+
+// asError converts err into an Error, for reporting to the client.
+func asError(err error) *Error {
+	switch e := err.(type) {
+	case *Error:
+		return e
+	case *ForbiddenError:
+		return newError(http.StatusForbidden, ReasonPermissionDenied, "%s", e.Message)
+	default:
+		if err == ErrLeaseNotRenewable {
+			return newError(http.StatusConflict, ReasonNotRenewable, "%v", err)
+		}
+
+		return newError(http.StatusInternalServerError, ReasonInternal, "%v", err)
+	}
+}
+
+// writeError writes err as a failed DriverStatus,
+// with the status code of the error.
+func writeError(w http.ResponseWriter, err error) {
+	e := asError(err)
+
+	b, merr := json.Marshal(e)
+	if merr != nil {
+		klog.Errorf("error writing json: %v", merr)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code)
+	w.Write(b)
+}
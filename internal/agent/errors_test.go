@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/StatCan/boathouse/internal/flexvol"
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestVaultError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   int
+		reason string
+	}{
+		{name: "bad request", err: &vault.ResponseError{StatusCode: 400, Errors: []string{"invalid ttl"}}, code: http.StatusBadRequest, reason: ReasonBadRequest},
+		{name: "forbidden", err: &vault.ResponseError{StatusCode: 403}, code: http.StatusForbidden, reason: ReasonPermissionDenied},
+		{name: "not found", err: &vault.ResponseError{StatusCode: 404}, code: http.StatusNotFound, reason: ReasonNotFound},
+		{name: "rate limited", err: &vault.ResponseError{StatusCode: 429}, code: http.StatusTooManyRequests, reason: ReasonRateLimited},
+		{name: "sealed", err: &vault.ResponseError{StatusCode: 503}, code: http.StatusServiceUnavailable, reason: ReasonUnavailable},
+		{name: "other status", err: &vault.ResponseError{StatusCode: 500, Errors: []string{"internal error"}}, code: http.StatusBadGateway, reason: ReasonVaultError},
+		{name: "unreachable", err: errors.New("connection refused"), code: http.StatusServiceUnavailable, reason: ReasonUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := vaultError(tt.err, "minio/keys/team-a")
+			e, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("got %T, want an *Error", err)
+			}
+
+			if e.Code != tt.code || e.Reason != tt.reason {
+				t.Errorf("got %d %s, want %d %s", e.Code, e.Reason, tt.code, tt.reason)
+			}
+		})
+	}
+
+	if err := vaultError(nil, "minio/keys/team-a"); err != nil {
+		t.Errorf("got %v for no error, want nil", err)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   int
+		reason string
+	}{
+		{name: "error", err: newError(http.StatusNotFound, ReasonNotFound, "no such path"), code: http.StatusNotFound, reason: ReasonNotFound},
+		{name: "forbidden", err: &ForbiddenError{Message: "permission denied"}, code: http.StatusForbidden, reason: ReasonPermissionDenied},
+		{name: "not renewable", err: ErrLeaseNotRenewable, code: http.StatusConflict, reason: ReasonNotRenewable},
+		{name: "other", err: errors.New("failed"), code: http.StatusInternalServerError, reason: ReasonInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeError(w, tt.err)
+
+			if w.Code != tt.code {
+				t.Errorf("got status %d, want %d", w.Code, tt.code)
+			}
+
+			var e Error
+			if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if e.Reason != tt.reason || e.Status != string(flexvol.StatusFailure) {
+				t.Errorf("got %s %s, want Failure %s", e.Status, e.Reason, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Field describes where a credential field is found in the data of a Vault secret.
+type Field struct {
+	// Keys are the keys of the Vault secret data holding the field,
+	// in order of preference.
+	Keys []string `mapstructure:"keys"`
+
+	// Required fails issuance when none of the keys are present.
+	Required bool `mapstructure:"required"`
+}
+
+// FieldMapping maps the data of secrets read under a Vault path prefix to credential fields.
+type FieldMapping struct {
+	// PathPrefix is the prefix of the Vault paths the mapping applies to.
+	PathPrefix string `mapstructure:"pathPrefix"`
+
+	// Fields maps credential fields (e.g., access_key) to the Vault data keys.
+	Fields map[string]Field `mapstructure:"fields"`
+}
+
+// defaultFields are the fields extracted from secrets
+// when no mapping matches the Vault path.
+var defaultFields = map[string]Field{
+	// MinIO and AWS secrets engines
+	"access_key":    {Keys: []string{"accessKeyId", "access_key"}},
+	"secret_key":    {Keys: []string{"secretAccessKey", "secret_key"}},
+	"session_token": {Keys: []string{"sessionToken", "security_token", "session_token"}},
+
+	// Azure storage accounts
+	"account_name": {Keys: []string{"accountName", "account_name"}},
+	"account_key":  {Keys: []string{"accountKey", "account_key"}},
+	"sas_token":    {Keys: []string{"sasToken", "sas_token"}},
+
+	// Azure secrets engine
+	"client_id":     {Keys: []string{"client_id"}},
+	"client_secret": {Keys: []string{"client_secret"}},
+
+	// AD and LDAP secrets engines
+	"username": {Keys: []string{"username"}},
+	"password": {Keys: []string{"current_password", "password"}},
+}
+
+// credentialFields returns the fields of the response which
+// can be populated from secret data, by their JSON name.
+func credentialFields(r *IssueCredentialResponse) map[string]*string {
+	return map[string]*string{
+		"access_key":    &r.AccessKey,
+		"secret_key":    &r.SecretKey,
+		"session_token": &r.SessionToken,
+		"account_name":  &r.AccountName,
+		"account_key":   &r.AccountKey,
+		"sas_token":     &r.SASToken,
+		"client_id":     &r.ClientID,
+		"client_secret": &r.ClientSecret,
+		"username":      &r.Username,
+		"password":      &r.Password,
+	}
+}
+
+// validateFieldMappings checks that mappings only refer to known credential fields.
+func validateFieldMappings(mappings []FieldMapping) error {
+	known := credentialFields(&IssueCredentialResponse{})
+
+	for _, mapping := range mappings {
+		for name, field := range mapping.Fields {
+			if _, ok := known[name]; !ok {
+				return fmt.Errorf("field mapping for %q: unknown credential field %q", mapping.PathPrefix, name)
+			}
+
+			if len(field.Keys) == 0 {
+				return fmt.Errorf("field mapping for %q: no keys for credential field %q", mapping.PathPrefix, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// fieldsForPath returns the fields of the mapping with
+// the longest prefix matching path, or the default fields.
+func (a *Agent) fieldsForPath(path string) map[string]Field {
+	fields := defaultFields
+	longest := -1
+
+	for _, mapping := range a.settings().Fields {
+		if strings.HasPrefix(path, mapping.PathPrefix) && len(mapping.PathPrefix) > longest {
+			fields = mapping.Fields
+			longest = len(mapping.PathPrefix)
+		}
+	}
+
+	return fields
+}
+
+// extractFields populates the response from the data of the secret read at path.
+func extractFields(path string, fields map[string]Field, data map[string]interface{}, response *IssueCredentialResponse) error {
+	targets := credentialFields(response)
+
+	// Sort for consistent error messages
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := fields[name]
+		found := false
+
+		for _, key := range field.Keys {
+			val, ok := data[key]
+			if !ok || val == nil {
+				continue
+			}
+
+			sval, ok := val.(string)
+			if !ok {
+				return fmt.Errorf("field %s (key %q) of secret at %s is a %T, not a string", name, key, path, val)
+			}
+
+			*targets[name] = sval
+			found = true
+			break
+		}
+
+		if !found && field.Required {
+			return fmt.Errorf("required field %s (keys %s) missing from secret at %s", name, strings.Join(field.Keys, ", "), path)
+		}
+	}
+
+	return nil
+}
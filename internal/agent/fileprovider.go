@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+	"k8s.io/klog"
+)
+
+// staticCredentials is the file of a static provider.
+type staticCredentials struct {
+	// Secrets are the data of each path, mapped to
+	// credential fields as for secrets read from Vault.
+	Secrets map[string]map[string]string `yaml:"secrets"`
+}
+
+// fileProvider issues static credentials read from a file,
+// for clusters without Vault. The file is re-read for each
+// request, so that changes are picked up on refresh.
+type fileProvider struct {
+	name     string
+	prefixes []string
+	file     string
+	refresh  time.Duration
+	fields   func(path string) map[string]Field
+}
+
+func newFileProvider(config ProviderConfig, fields func(path string) map[string]Field) *fileProvider {
+	return &fileProvider{
+		name:     config.Name,
+		prefixes: config.PathPrefixes,
+		file:     config.File,
+		refresh:  config.RefreshInterval,
+		fields:   fields,
+	}
+}
+
+func (p *fileProvider) Name() string {
+	return p.name
+}
+
+func (p *fileProvider) PathPrefixes() []string {
+	return p.prefixes
+}
+
+func (p *fileProvider) Issue(ctx context.Context, req IssueCredentialRequest) (*IssueCredentialResponse, error) {
+	if req.PublicKey != "" || req.Engine != "" {
+		return nil, newError(http.StatusBadRequest, ReasonBadRequest, "provider %s only issues static credentials", p.name)
+	}
+
+	b, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return nil, newError(http.StatusServiceUnavailable, ReasonUnavailable, "unable to read credentials of provider %s: %v", p.name, err)
+	}
+
+	var creds staticCredentials
+	if err := yaml.UnmarshalStrict(b, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", p.file, err)
+	}
+
+	secret, ok := creds.Secrets[req.Path]
+	if !ok {
+		return nil, newError(http.StatusNotFound, ReasonNotFound, "no such path %s", req.Path)
+	}
+
+	data := make(map[string]interface{}, len(secret))
+	for key, val := range secret {
+		data[key] = val
+	}
+
+	response := IssueCredentialResponse{
+		Lease: staticLease(p.name, req.TTL, p.refresh),
+	}
+
+	if err := extractFields(req.Path, p.fields(req.Path), data, &response); err != nil {
+		klog.Warningf("unable to extract credentials at %s: %v", req.Path, err)
+		return nil, err
+	}
+
+	klog.Infof("read static credentials: %s from %s, refreshing at %v", req.Path, p.name, response.Lease.Expiry)
+
+	return &response, nil
+}
+
+func (p *fileProvider) Renew(ctx context.Context, req RenewLeaseRequest) (*Lease, error) {
+	return nil, ErrLeaseNotRenewable
+}
+
+// Revoke does nothing, as static credentials cannot be revoked.
+func (p *fileProvider) Revoke(ctx context.Context, req RevokeLeaseRequest) error {
+	return nil
+}
+
+// Checks checks that the file of credentials is readable.
+func (p *fileProvider) Checks() []HealthCheck {
+	check := HealthCheck{
+		Name: fmt.Sprintf("provider/%s", p.name),
+		OK:   true,
+	}
+
+	if f, err := os.Open(p.file); err != nil {
+		check.OK = false
+		check.Message = err.Error()
+	} else {
+		f.Close()
+	}
+
+	return []HealthCheck{check}
+}
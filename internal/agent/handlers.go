@@ -4,11 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/StatCan/boathouse/pkg/api"
 	"k8s.io/klog"
 
 	vault "github.com/hashicorp/vault/api"
@@ -16,79 +18,250 @@ import (
 
 // HandleIssueCredentials issues credentials from an HTTP request
 func (a *Agent) HandleIssueCredentials(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
+	var req IssueCredentialRequest
+	if err := decodeRequest(r, &req); err != nil {
+		klog.Errorf("error decoding request: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	creds, err := a.IssueCredentials(r.Context(), req)
 	if err != nil {
-		klog.Errorf("error reading body: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		klog.Errorf("error issuing credentials: %v", err)
+		writeError(w, err)
 		return
 	}
-	defer r.Body.Close()
 
-	var req IssueCredentialRequest
-	err = json.Unmarshal(body, &req)
+	b, err := json.Marshal(creds)
 	if err != nil {
-		klog.Errorf("error decoding body: %v", err)
-		w.WriteHeader(http.StatusBadRequest)
+		klog.Errorf("error writing json: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	creds, err := a.IssueCredentials(r.Context(), req)
-	if err != nil { // TODO: set status code based on error. Ex. 404 for not found
-		klog.Errorf("error issuing credentials: %v", err)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// HandleRenewLease renews a lease from an HTTP request
+func (a *Agent) HandleRenewLease(w http.ResponseWriter, r *http.Request) {
+	var req RenewLeaseRequest
+	if err := decodeRequest(r, &req); err != nil {
+		klog.Errorf("error decoding request: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	lease, err := a.RenewLease(r.Context(), req)
+	if err == ErrLeaseNotRenewable {
+		writeError(w, err)
+		return
+	} else if err != nil {
+		klog.Errorf("error renewing lease: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	b, err := json.Marshal(lease)
+	if err != nil {
+		klog.Errorf("error writing json: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	b, err := json.Marshal(creds)
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+// HandleRevokeLease revokes a lease from an HTTP request
+func (a *Agent) HandleRevokeLease(w http.ResponseWriter, r *http.Request) {
+	var req RevokeLeaseRequest
+	if err := decodeRequest(r, &req); err != nil {
+		klog.Errorf("error decoding request: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	if req.LeaseID == "" {
+		writeError(w, newError(http.StatusBadRequest, ReasonBadRequest, "invalid request: lease_id is required"))
+		return
+	}
+
+	if err := a.RevokeLease(r.Context(), req); err != nil {
+		klog.Errorf("error revoking lease: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// HandleListLeases lists the leases held by mounts
+func (a *Agent) HandleListLeases(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(LeaseList{Leases: a.cache.list()})
 	if err != nil {
 		klog.Errorf("error writing json: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(b)
 }
 
-// IssueCredentials issues the requested credentials
-func (a *Agent) IssueCredentials(ctx context.Context, req IssueCredentialRequest) (*IssueCredentialResponse, error) {
+// decodeRequest decodes the JSON body of the request into req,
+// refusing bodies larger than api.MaxRequestBytes.
+func decodeRequest(r *http.Request, req interface{}) error {
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, api.MaxRequestBytes+1))
+	if err != nil {
+		return newError(http.StatusBadRequest, ReasonBadRequest, "error reading request: %v", err)
+	}
+
+	if len(body) > api.MaxRequestBytes {
+		return newError(http.StatusRequestEntityTooLarge, ReasonTooLarge, "request exceeds %d bytes", api.MaxRequestBytes)
+	}
+
+	if err := json.Unmarshal(body, req); err != nil {
+		return newError(http.StatusBadRequest, ReasonBadRequest, "invalid request: %v", err)
+	}
+
+	return nil
+}
+
+// IssueCredentials issues the requested credentials,
+// sharing cached credentials where possible
+func (a *Agent) IssueCredentials(ctx context.Context, req IssueCredentialRequest) (creds *IssueCredentialResponse, err error) {
+	start := time.Now()
+	defer func() {
+		observeIssuance(a.metricsPrefix(req.Path), start, err)
+		a.auditIssue(ctx, req, creds, err)
+	}()
+
+	p, err := a.provider(req.Target, req.Path)
+	if err != nil {
+		return nil, err
+	}
+	req.Target = p.Name()
+
+	if policy := a.currentPolicy(); policy != nil {
+		if err := policy.Authorize(req, a.defaultProvider.Name()); err != nil {
+			return nil, err
+		}
+	}
+
+	if scoped, ok := p.(podScoped); (ok && scoped.podScoped()) || !a.cache.cacheable(req) {
+		creds, err := p.Issue(ctx, req)
+		if err == nil {
+			a.cache.track(req.Path, req.PodIdentity, creds)
+		}
+		return creds, err
+	}
+
+	return a.cache.get(req, func() (*IssueCredentialResponse, error) {
+		return p.Issue(ctx, req)
+	})
+}
+
+// HandleTokenHealth reports the state of the agent's Vault tokens
+func (a *Agent) HandleTokenHealth(w http.ResponseWriter, r *http.Request) {
+	health := a.TokenHealth()
+
+	valid := true
+	for _, h := range health {
+		valid = valid && h.Valid
+	}
+
+	b, err := json.Marshal(health)
+	if err != nil {
+		klog.Errorf("error writing json: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if valid {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
+}
+
+// issueCredentials issues new credentials from the Vault target
+func (a *Agent) issueCredentials(ctx context.Context, t *target, req IssueCredentialRequest) (*IssueCredentialResponse, error) {
 	var creds *vault.Secret
-	var err error
 
-	klog.Infof("issuing credentials: %s with TTL %v", req.Path, req.TTL)
+	vc, err := t.vaultFor(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.PublicKey != "" {
+		return a.SignPublicKey(ctx, vc, req)
+	}
+
+	switch req.Engine {
+	case "":
+	case EngineKVv2:
+		return a.ReadKVv2(ctx, vc, req)
+	default:
+		return nil, fmt.Errorf("unsupported secrets engine %q", req.Engine)
+	}
+
+	klog.Infof("issuing credentials: %s with TTL %v from %s", req.Path, req.TTL, t.name)
 
 	if req.TTL == 0 {
-		creds, err = a.vault.Logical().Read(req.Path)
+		creds, err = vc.Logical().Read(req.Path)
 	} else {
-		creds, err = a.vault.Logical().ReadWithData(req.Path, map[string][]string{
+		creds, err = vc.Logical().ReadWithData(req.Path, map[string][]string{
 			"ttl": {strconv.FormatInt(int64(req.TTL.Seconds()), 10)},
 		})
 	}
 	if err != nil {
 		klog.Warningf("unable to obtain MinIO token at %s: %v", req.Path, err)
-		return nil, err
+		if t.pods == nil {
+			t.refused(err)
+		}
+		return nil, vaultError(err, req.Path)
 	}
 
 	if creds == nil {
-		return nil, fmt.Errorf("failure: no response returned from vault")
+		return nil, notFound(req.Path)
 	}
 
 	response := IssueCredentialResponse{
 		Lease: Lease{
-			ID:     creds.LeaseID,
-			Expiry: time.Now().Add(time.Duration(creds.LeaseDuration) * time.Second),
+			ID:        creds.LeaseID,
+			Expiry:    time.Now().Add(time.Duration(creds.LeaseDuration) * time.Second),
+			Renewable: creds.Renewable,
+			Target:    t.name,
 		},
 	}
 
-	if val, ok := creds.Data["accessKeyId"]; ok {
-		response.AccessKey = val.(string)
+	if err = extractFields(req.Path, a.fieldsForPath(req.Path), creds.Data, &response); err != nil {
+		klog.Warningf("unable to extract credentials at %s: %v", req.Path, err)
+		return nil, err
 	}
 
-	if val, ok := creds.Data["secretAccessKey"]; ok {
-		response.SecretKey = val.(string)
+	// The lease is revoked by Vault if the pod's token expires before it
+	if t.pods != nil && response.Lease.ID != "" {
+		t.pods.hold(req.Namespace, req.ServiceAccount, response.Lease)
 	}
 
-	klog.Infof("issued credentials: %s, expiring at %v", response.AccessKey, response.Lease.Expiry)
+	klog.Infof("issued credentials: %s, expiring at %v", response.Lease.ID, response.Lease.Expiry)
 
 	return &response, nil
 }
+
+// stringField returns the value of the first of keys present in data.
+func stringField(data map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if val, ok := data[key].(string); ok {
+			return val
+		}
+	}
+
+	return ""
+}
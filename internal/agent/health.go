@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/StatCan/boathouse/pkg/api"
+	"k8s.io/klog"
+)
+
+// Health statuses.
+const (
+	HealthOK          = api.HealthOK
+	HealthUnavailable = api.HealthUnavailable
+)
+
+type (
+	HealthCheck = api.HealthCheck
+	Health      = api.Health
+)
+
+// SetListening records whether the agent's listener is bound.
+func (a *Agent) SetListening(listening bool) {
+	var val int32
+	if listening {
+		val = 1
+	}
+
+	atomic.StoreInt32(&a.listening, val)
+}
+
+// Readiness checks that the agent's listener is bound, and
+// that each credential provider is ready to issue credentials.
+func (a *Agent) Readiness() Health {
+	checks := []HealthCheck{
+		{
+			Name: "listener",
+			OK:   atomic.LoadInt32(&a.listening) == 1,
+		},
+	}
+
+	for _, p := range a.providers {
+		checks = append(checks, p.Checks()...)
+	}
+
+	health := Health{
+		Status: HealthOK,
+		Checks: checks,
+	}
+
+	for _, check := range checks {
+		if !check.OK {
+			health.Status = HealthUnavailable
+		}
+	}
+
+	return health
+}
+
+// sealCheck checks that the target's Vault is unsealed.
+func (t *target) sealCheck() HealthCheck {
+	check := HealthCheck{
+		Name: fmt.Sprintf("vault/%s", t.name),
+	}
+
+	status, err := t.vault.Sys().SealStatus()
+	switch {
+	case err != nil:
+		check.Message = err.Error()
+	case status.Sealed:
+		check.Message = "vault is sealed"
+	default:
+		check.OK = true
+	}
+
+	return check
+}
+
+// HandleHealthz reports that the agent is running
+func (a *Agent) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, Health{Status: HealthOK})
+}
+
+// HandleReadyz reports whether the agent is ready to issue credentials
+func (a *Agent) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, a.Readiness())
+}
+
+// writeHealth writes the health, with a status code of 200
+// if healthy, and 503 otherwise.
+func writeHealth(w http.ResponseWriter, health Health) {
+	b, err := json.Marshal(health)
+	if err != nil {
+		klog.Errorf("error writing json: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status == HealthOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(b)
+}
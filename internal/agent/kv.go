@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"k8s.io/klog"
+)
+
+// EngineKVv2 identifies static secrets stored in the KV version 2 secrets engine.
+const EngineKVv2 = "kv-v2"
+
+// defaultKVRefreshInterval is how often static secrets are re-read, by default.
+const defaultKVRefreshInterval = 5 * time.Minute
+
+// ReadKVv2 reads a static secret from the KV version 2 secrets engine.
+// Static secrets have no lease, so a synthetic lease is returned
+// which expires when the secret should be re-read.
+func (a *Agent) ReadKVv2(ctx context.Context, vc *vault.Client, req IssueCredentialRequest) (*IssueCredentialResponse, error) {
+	path, err := kvDataPath(vc, req.Path)
+	if err != nil {
+		klog.Warningf("unable to resolve KV path %s: %v", req.Path, err)
+		return nil, err
+	}
+
+	klog.Infof("reading static credentials: %s (version %d)", path, req.Version)
+
+	data := map[string][]string{}
+	if req.Version != 0 {
+		data["version"] = []string{strconv.Itoa(req.Version)}
+	}
+
+	secret, err := vc.Logical().ReadWithData(path, data)
+	if err != nil {
+		klog.Warningf("unable to read static credentials at %s: %v", path, err)
+		return nil, vaultError(err, req.Path)
+	}
+
+	if secret == nil {
+		return nil, notFound(req.Path)
+	}
+
+	secretData, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		// Deleted and destroyed versions have no data
+		return nil, newError(http.StatusNotFound, ReasonNotFound, "no data in secret at %s (version %d)", req.Path, req.Version)
+	}
+
+	response := IssueCredentialResponse{
+		Lease: staticLease(req.Target, req.TTL, a.settings().KVRefreshInterval),
+	}
+
+	if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+		if version, ok := metadata["version"]; ok {
+			response.Version, _ = strconv.Atoi(fmt.Sprint(version))
+		}
+	}
+
+	if err = extractFields(req.Path, a.fieldsForPath(req.Path), secretData, &response); err != nil {
+		klog.Warningf("unable to extract credentials at %s: %v", path, err)
+		return nil, err
+	}
+
+	klog.Infof("read static credentials: %s (version %d), refreshing at %v", path, response.Version, response.Lease.Expiry)
+
+	return &response, nil
+}
+
+// kvDataPath converts a path within a KV version 2 secrets engine
+// (e.g., secret/buckets/foo) to the path of its data (e.g., secret/data/buckets/foo).
+// Paths which already refer to the data are returned unchanged.
+func kvDataPath(vc *vault.Client, path string) (string, error) {
+	mount, err := vc.Logical().Read(fmt.Sprintf("sys/internal/ui/mounts/%s", path))
+	if err != nil {
+		return "", vaultError(err, path)
+	}
+
+	if mount == nil {
+		return "", newError(http.StatusNotFound, ReasonNotFound, "no secrets engine is mounted at %s", path)
+	}
+
+	mountPath, ok := mount.Data["path"].(string)
+	if !ok || !strings.HasPrefix(path, mountPath) {
+		return "", fmt.Errorf("unable to determine the mount of %s", path)
+	}
+
+	rest := strings.TrimPrefix(path, mountPath)
+	if strings.HasPrefix(rest, "data/") {
+		return path, nil
+	}
+
+	return fmt.Sprintf("%sdata/%s", mountPath, rest), nil
+}
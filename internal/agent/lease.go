@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"k8s.io/klog"
+)
+
+// ErrLeaseNotRenewable is returned when a lease can no longer be renewed,
+// and new credentials must be issued instead.
+var ErrLeaseNotRenewable = errors.New("lease is not renewable")
+
+// RenewLease renews a lease, provided it is renewable
+// and has not reached its maximum TTL.
+func (a *Agent) RenewLease(ctx context.Context, req RenewLeaseRequest) (resp *RenewLeaseResponse, err error) {
+	leasePath := a.leasePath(req.LeaseID)
+	defer func() {
+		a.auditRenew(ctx, req, leasePath, resp, err)
+	}()
+
+	if err := a.authorizeLease(req.LeaseID, req.Target, req.PodIdentity); err != nil {
+		return nil, err
+	}
+
+	p, err := a.provider(req.Target, req.LeaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("renewing lease: %s with increment %v", req.LeaseID, req.Increment)
+
+	lease, err := p.Renew(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	a.cache.renewed(*lease)
+
+	klog.Infof("renewed lease: %s, expiring at %v", req.LeaseID, lease.Expiry)
+
+	return &RenewLeaseResponse{Lease: *lease}, nil
+}
+
+// RevokeLease revokes a lease, so that its credentials are no longer usable.
+func (a *Agent) RevokeLease(ctx context.Context, req RevokeLeaseRequest) (err error) {
+	// The path is looked up first, as revoking the lease releases it
+	leasePath := a.leasePath(req.LeaseID)
+	defer func() {
+		a.auditRevoke(ctx, req, leasePath, err)
+	}()
+
+	if err := a.authorizeLease(req.LeaseID, req.Target, req.PodIdentity); err != nil {
+		return err
+	}
+
+	p, err := a.provider(req.Target, req.LeaseID)
+	if err != nil {
+		return err
+	}
+
+	// Leases shared between mounts are only revoked once released by all of them
+	if !a.cache.release(req.LeaseID, req.PodIdentity) {
+		return nil
+	}
+
+	klog.Infof("revoking lease: %s", req.LeaseID)
+
+	if err := p.Revoke(ctx, req); err != nil {
+		return err
+	}
+
+	klog.Infof("revoked lease: %s", req.LeaseID)
+
+	return nil
+}
+
+// leasePath returns the path the lease was issued for. Leases which are
+// not held are assumed to be Vault leases, whose IDs begin with the path.
+func (a *Agent) leasePath(leaseID string) string {
+	if p, ok := a.cache.path(leaseID); ok {
+		return p
+	}
+
+	return path.Dir(leaseID)
+}
+
+// authorizeLease checks that the pod may renew or revoke the lease.
+// Leases held by mounts may only be renewed or revoked by those mounts.
+// Other leases (e.g., issued before the agent restarted) may be, by pods
+// the policy allows to read their path, which lease IDs begin with.
+func (a *Agent) authorizeLease(leaseID, target string, pod PodIdentity) error {
+	if holder, held := a.cache.holds(leaseID, pod); held {
+		if holder {
+			return nil
+		}
+
+		return &ForbiddenError{
+			Message: fmt.Sprintf("permission denied on lease %s: not held by the requesting mount", leaseID),
+		}
+	}
+
+	policy := a.currentPolicy()
+	if policy == nil {
+		return nil
+	}
+
+	p, err := a.provider(target, leaseID)
+	if err != nil {
+		return err
+	}
+
+	return policy.Authorize(IssueCredentialRequest{
+		Path:        path.Dir(leaseID),
+		Target:      p.Name(),
+		PodIdentity: pod,
+	}, a.defaultProvider.Name())
+}
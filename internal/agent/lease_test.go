@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// testAgent returns an agent with the policy, whose
+// default provider is a static provider named default.
+func testAgent(policy *Policy) *Agent {
+	def := newFileProvider(ProviderConfig{Name: DefaultTarget, Type: ProviderStatic}, nil)
+
+	return &Agent{
+		cache:           newLeaseCache(CacheConfig{}, false),
+		policy:          policy,
+		providers:       []CredentialProvider{def},
+		defaultProvider: def,
+	}
+}
+
+func TestAuthorizeLease(t *testing.T) {
+	const id = "minio/keys/team-a/1"
+	holder := testRequest("a").PodIdentity
+	other := testRequest("b").PodIdentity
+	otherTeam := PodIdentity{Namespace: "team-b", PodUID: "c", MountPath: "/mnt/c"}
+
+	policy := &Policy{
+		Rules: []PolicyRule{
+			{Namespaces: []string{"team-a"}, Paths: []string{"minio/keys/team-a"}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		held    bool
+		pod     PodIdentity
+		allowed bool
+	}{
+		{name: "holder", held: true, pod: holder, allowed: true},
+		{name: "other mount of the namespace", held: true, pod: other},
+		{name: "unidentified mount", held: true, pod: PodIdentity{}},
+		{name: "holder with a policy", policy: policy, held: true, pod: holder, allowed: true},
+		{name: "unheld lease without a policy", pod: otherTeam, allowed: true},
+		{name: "unheld lease allowed by the policy", policy: policy, pod: other, allowed: true},
+		{name: "unheld lease denied by the policy", policy: policy, pod: otherTeam},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testAgent(tt.policy)
+
+			if tt.held {
+				a.cache.track("minio/keys/team-a", holder, testCreds(id, time.Hour))
+			}
+
+			err := a.authorizeLease(id, "", tt.pod)
+			if tt.allowed && err != nil {
+				t.Errorf("got %v, want allowed", err)
+			}
+			if !tt.allowed {
+				if _, ok := err.(*ForbiddenError); !ok {
+					t.Errorf("got %v, want a ForbiddenError", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRevokeLeaseAuditsPath(t *testing.T) {
+	holder := testRequest("a").PodIdentity
+
+	tests := []struct {
+		name     string
+		leaseID  string
+		held     bool
+		wantPath string
+	}{
+		// Leases of providers do not begin with their path
+		{name: "held lease", leaseID: "static-1", held: true, wantPath: "minio/keys/team-a"},
+		{name: "unheld lease", leaseID: "minio/keys/team-b/1", wantPath: "minio/keys/team-b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			a := testAgent(nil)
+			a.audit = &auditLog{w: &buf}
+
+			if tt.held {
+				a.cache.track("minio/keys/team-a", holder, testCreds(tt.leaseID, time.Hour))
+			}
+
+			if err := a.RevokeLease(context.Background(), RevokeLeaseRequest{LeaseID: tt.leaseID, PodIdentity: holder}); err != nil {
+				t.Fatalf("RevokeLease: %v", err)
+			}
+
+			var rec AuditRecord
+			if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+				t.Fatalf("failed to decode audit record: %v", err)
+			}
+			if rec.Operation != AuditRevoke || rec.Path != tt.wantPath {
+				t.Errorf("got %s of path %q, want revoke of %q", rec.Operation, rec.Path, tt.wantPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "boathouse_agent"
+
+// otherPrefix labels issuances for paths without a configured prefix.
+const otherPrefix = "other"
+
+var (
+	issuanceTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "issuance_total",
+		Help:      "Credential issuance requests, by configured path prefix and result.",
+	}, []string{"prefix", "result"})
+
+	issuanceDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "issuance_duration_seconds",
+		Help:      "Latency of credential issuance requests, by configured path prefix and result.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"prefix", "result"})
+
+	vaultErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "vault_errors_total",
+		Help:      "Failed Vault requests, by response status.",
+	}, []string{"status"})
+
+	activeLeasesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "active_leases"),
+		"Vault leases held by mounts.",
+		nil, nil,
+	)
+
+	nearestExpiryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "lease_nearest_expiry_seconds"),
+		"Time until the earliest expiry of the leases held by mounts.",
+		nil, nil,
+	)
+)
+
+func init() {
+	prometheus.MustRegister(issuanceTotal, issuanceDuration, vaultErrorsTotal)
+}
+
+// metricsPrefix returns the longest prefix of path configured for
+// a provider, a Vault target or a field mapping, or otherPrefix.
+// Paths are chosen by pods, so only configured prefixes are used
+// as labels, to bound the number of series.
+func (a *Agent) metricsPrefix(path string) string {
+	prefixes := []string{}
+	for _, p := range a.providers {
+		prefixes = append(prefixes, p.PathPrefixes()...)
+	}
+	for _, mapping := range a.settings().Fields {
+		prefixes = append(prefixes, mapping.PathPrefix)
+	}
+
+	match := otherPrefix
+	length := 0
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > length {
+			match = prefix
+			length = len(prefix)
+		}
+	}
+
+	return match
+}
+
+// observeIssuance records the result and latency of an issuance.
+func observeIssuance(prefix string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = asError(err).Reason
+	}
+
+	issuanceTotal.WithLabelValues(prefix, result).Inc()
+	issuanceDuration.WithLabelValues(prefix, result).Observe(time.Since(start).Seconds())
+}
+
+// observeVaultError records a failed Vault request.
+func observeVaultError(status int) {
+	label := "unreachable"
+	if status != 0 {
+		label = strconv.Itoa(status)
+	}
+
+	vaultErrorsTotal.WithLabelValues(label).Inc()
+}
+
+// Describe implements prometheus.Collector, for the leases held by mounts.
+func (a *Agent) Describe(ch chan<- *prometheus.Desc) {
+	ch <- activeLeasesDesc
+	ch <- nearestExpiryDesc
+}
+
+// Collect implements prometheus.Collector, for the leases held by mounts.
+func (a *Agent) Collect(ch chan<- prometheus.Metric) {
+	count, nearest := a.cache.held()
+
+	ch <- prometheus.MustNewConstMetric(activeLeasesDesc, prometheus.GaugeValue, float64(count))
+	if count > 0 {
+		ch <- prometheus.MustNewConstMetric(nearestExpiryDesc, prometheus.GaugeValue, time.Until(nearest).Seconds())
+	}
+}
@@ -0,0 +1,32 @@
+package agent
+
+import "testing"
+
+func TestMetricsPrefix(t *testing.T) {
+	a := testAgent(nil)
+	a.providers = append(a.providers, newFileProvider(ProviderConfig{
+		Name:         "dev",
+		Type:         ProviderStatic,
+		PathPrefixes: []string{"static/", "static/team-a/"},
+	}, nil))
+	a.config.Fields = []FieldMapping{{PathPrefix: "minio/keys/"}}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "static/team-b", want: "static/"},
+		{path: "static/team-a/minio", want: "static/team-a/"},
+		{path: "minio/keys/team-a", want: "minio/keys/"},
+		{path: "minio/other/team-a", want: otherPrefix},
+		{path: "", want: otherPrefix},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := a.metricsPrefix(tt.path); got != tt.want {
+				t.Errorf("metricsPrefix(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
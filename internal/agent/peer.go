@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"net"
+
+	"k8s.io/klog"
+)
+
+// PeerCredentials identify the process connected to the agent's socket.
+type PeerCredentials struct {
+	PID int32  `json:"pid"`
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+type peerContextKey struct{}
+
+// PeerFromContext returns the credentials of the peer making the request.
+func PeerFromContext(ctx context.Context) (PeerCredentials, bool) {
+	peer, ok := ctx.Value(peerContextKey{}).(PeerCredentials)
+	return peer, ok
+}
+
+// PeerListener accepts connections to a unix socket only
+// from processes running as permitted users or groups.
+type PeerListener struct {
+	*net.UnixListener
+
+	// AllowedUIDs and AllowedGIDs are the users and groups which may connect.
+	AllowedUIDs []uint32
+	AllowedGIDs []uint32
+}
+
+// peerConn is a connection whose peer's credentials are known.
+type peerConn struct {
+	*net.UnixConn
+	peer PeerCredentials
+}
+
+// Accept waits for a connection from a permitted peer.
+// Connections from other peers are closed.
+func (l *PeerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return nil, err
+		}
+
+		peer, err := peerCredentials(conn)
+		if err != nil {
+			klog.Warningf("rejecting connection: unable to read peer credentials: %v", err)
+			conn.Close()
+			continue
+		}
+
+		if !l.allowed(peer) {
+			klog.Warningf("rejecting connection from pid %d (uid %d, gid %d)", peer.PID, peer.UID, peer.GID)
+			conn.Close()
+			continue
+		}
+
+		return &peerConn{UnixConn: conn, peer: peer}, nil
+	}
+}
+
+func (l *PeerListener) allowed(peer PeerCredentials) bool {
+	for _, uid := range l.AllowedUIDs {
+		if peer.UID == uid {
+			return true
+		}
+	}
+
+	for _, gid := range l.AllowedGIDs {
+		if peer.GID == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ConnContext makes the credentials of the peers of connections accepted
+// by the listener available to handlers. It is intended for use as the
+// ConnContext of an http.Server.
+func (l *PeerListener) ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if pc, ok := conn.(*peerConn); ok {
+		return context.WithValue(ctx, peerContextKey{}, pc.peer)
+	}
+
+	return ctx
+}
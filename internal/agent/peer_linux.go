@@ -0,0 +1,32 @@
+package agent
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials reads the credentials of the peer with SO_PEERCRED.
+func peerCredentials(conn *net.UnixConn) (PeerCredentials, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+
+	var ucred *syscall.Ucred
+	var uerr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, uerr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return PeerCredentials{}, err
+	}
+	if uerr != nil {
+		return PeerCredentials{}, uerr
+	}
+
+	return PeerCredentials{
+		PID: ucred.Pid,
+		UID: ucred.Uid,
+		GID: ucred.Gid,
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package agent
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is only supported on Linux.
+func peerCredentials(conn *net.UnixConn) (PeerCredentials, error) {
+	return PeerCredentials{}, errors.New("peer credentials are not supported on this platform")
+}
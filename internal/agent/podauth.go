@@ -0,0 +1,383 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/StatCan/boathouse/internal/kube"
+	vault "github.com/hashicorp/vault/api"
+	"k8s.io/klog"
+)
+
+const (
+	defaultPodAuthMountPath = "kubernetes"
+	defaultPodAuthRole      = "{{.Namespace}}-{{.ServiceAccount}}"
+
+	// podJWTExpiration is the lifetime of the service account
+	// tokens exchanged for Vault tokens.
+	podJWTExpiration = 10 * time.Minute
+
+	// podTokenRefreshBefore is how long before expiry
+	// a pod's Vault token is renewed or replaced.
+	podTokenRefreshBefore = 1 * time.Minute
+)
+
+// PodAuthConfig configures logging in to Vault's Kubernetes auth
+// method as the service account of the pod requesting credentials.
+// The agent must talk to Vault directly, rather than through a
+// Vault agent which replaces the token of each request.
+type PodAuthConfig struct {
+	// Enabled logs in as the requesting pod, rather than using the agent's token.
+	Enabled bool `mapstructure:"enabled"`
+
+	// MountPath is the path of the Kubernetes auth method.
+	MountPath string `mapstructure:"mountPath"`
+
+	// Role is a template of the Vault role, given
+	// the pod's .Namespace and .ServiceAccount.
+	Role string `mapstructure:"role"`
+
+	// Audiences of the service account tokens presented to Vault.
+	Audiences []string `mapstructure:"audiences"`
+}
+
+// podToken is a Vault token issued to a pod's service account.
+type podToken struct {
+	key       string
+	token     string
+	expiry    time.Time
+	renewable bool
+}
+
+// podLogin is an in-flight login, or renewal, of a service account's
+// token. Concurrent requests for the service account wait for it.
+type podLogin struct {
+	ready chan struct{}
+	token string
+	err   error
+}
+
+// podLease is a lease issued with a pod's token. Vault revokes
+// the lease if the token expires, so the token is kept alive.
+type podLease struct {
+	token  *podToken
+	expiry time.Time
+}
+
+// podAuthenticator logs in to Vault on behalf of pods, and caches
+// the resulting short-lived child tokens until they expire.
+type podAuthenticator struct {
+	config PodAuthConfig
+	role   *template.Template
+	vault  *vault.Client
+	kube   *kube.Client
+
+	// mu is not held across requests to Kubernetes or Vault
+	mu     sync.Mutex
+	tokens map[string]*podToken
+	logins map[string]*podLogin
+	leases map[string]*podLease
+}
+
+func newPodAuthenticator(config PodAuthConfig, vc *vault.Client) (*podAuthenticator, error) {
+	if config.MountPath == "" {
+		config.MountPath = defaultPodAuthMountPath
+	}
+
+	if config.Role == "" {
+		config.Role = defaultPodAuthRole
+	}
+
+	role, err := template.New("role").Option("missingkey=error").Parse(config.Role)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pod auth role: %v", err)
+	}
+
+	kc, err := kube.NewInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &podAuthenticator{
+		config: config,
+		role:   role,
+		vault:  vc,
+		kube:   kc,
+		tokens: map[string]*podToken{},
+		logins: map[string]*podLogin{},
+		leases: map[string]*podLease{},
+	}, nil
+}
+
+// Start keeps the tokens of held leases alive until ctx is done.
+func (p *podAuthenticator) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(podTokenRefreshBefore / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.keepAlive()
+			}
+		}
+	}()
+}
+
+// hold records a lease issued with the current token of the service account.
+func (p *podAuthenticator) hold(namespace, serviceAccount string, lease Lease) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if t, ok := p.tokens[fmt.Sprintf("%s/%s", namespace, serviceAccount)]; ok {
+		p.leases[lease.ID] = &podLease{token: t, expiry: lease.Expiry}
+	}
+}
+
+// renewed updates the expiry of a held lease.
+func (p *podAuthenticator) renewed(lease Lease) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.leases[lease.ID]; ok {
+		l.expiry = lease.Expiry
+	}
+}
+
+// released forgets a revoked lease, so that its token may expire.
+func (p *podAuthenticator) released(leaseID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.leases, leaseID)
+}
+
+// keepAlive renews the tokens of unexpired leases as they near expiry.
+// Tokens reaching their maximum TTL cannot be renewed, and their leases
+// are revoked by Vault when they expire.
+func (p *podAuthenticator) keepAlive() {
+	p.mu.Lock()
+	tokens := map[*podToken]time.Time{}
+	for id, l := range p.leases {
+		if time.Now().After(l.expiry) {
+			delete(p.leases, id)
+			continue
+		}
+
+		if l.expiry.After(tokens[l.token]) {
+			tokens[l.token] = l.expiry
+		}
+	}
+
+	renew := map[*podToken]string{}
+	for t, leaseExpiry := range tokens {
+		if t.live() || !t.expiry.Before(leaseExpiry) {
+			continue
+		}
+
+		if !t.renewable {
+			klog.Warningf("vault token for %s is not renewable: its leases will be revoked at %v", t.key, t.expiry)
+			continue
+		}
+
+		renew[t] = t.token
+	}
+	p.mu.Unlock()
+
+	for t, token := range renew {
+		expiry, renewable, err := p.renew(token)
+		if err != nil {
+			klog.Warningf("unable to renew vault token for %s, which holds leases: %v", t.key, err)
+			continue
+		}
+
+		p.mu.Lock()
+		t.expiry, t.renewable = expiry, renewable
+		p.mu.Unlock()
+	}
+}
+
+// client returns a Vault client authenticated as the pod's service account.
+func (p *podAuthenticator) client(ctx context.Context, namespace, serviceAccount string) (*vault.Client, error) {
+	if namespace == "" || serviceAccount == "" {
+		return nil, &ForbiddenError{
+			Message: "permission denied: the requesting pod's service account is unknown",
+		}
+	}
+
+	token, err := p.token(ctx, namespace, serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	vc, err := cloneClient(p.vault)
+	if err != nil {
+		return nil, err
+	}
+
+	vc.SetToken(token)
+	return vc, nil
+}
+
+// live reports whether the token can be used without renewal.
+func (p *podToken) live() bool {
+	return time.Until(p.expiry) > podTokenRefreshBefore
+}
+
+// token returns a live Vault token for the service account,
+// renewing or replacing the cached token as it nears expiry.
+// Requests for the same service account share a single login.
+func (p *podAuthenticator) token(ctx context.Context, namespace, serviceAccount string) (string, error) {
+	key := fmt.Sprintf("%s/%s", namespace, serviceAccount)
+
+	p.mu.Lock()
+
+	// Forget expired tokens
+	for k, t := range p.tokens {
+		if time.Now().After(t.expiry) {
+			delete(p.tokens, k)
+		}
+	}
+
+	if t, ok := p.tokens[key]; ok && t.live() {
+		p.mu.Unlock()
+		return t.token, nil
+	}
+
+	if login, ok := p.logins[key]; ok {
+		p.mu.Unlock()
+
+		select {
+		case <-login.ready:
+			return login.token, login.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	login := &podLogin{
+		ready: make(chan struct{}),
+	}
+	p.logins[key] = login
+
+	current, renewable := "", false
+	if t, ok := p.tokens[key]; ok {
+		current, renewable = t.token, t.renewable
+	}
+	p.mu.Unlock()
+
+	t, err := p.refresh(ctx, namespace, serviceAccount, current, renewable)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.logins, key)
+	if err == nil {
+		// A renewed token is updated in place, as its leases refer to it
+		if cached, ok := p.tokens[key]; ok && cached.token == t.token {
+			cached.expiry, cached.renewable = t.expiry, t.renewable
+		} else {
+			p.tokens[key] = t
+		}
+		login.token = t.token
+	}
+	login.err = err
+	close(login.ready)
+
+	return login.token, login.err
+}
+
+// refresh renews the current token of the service account, or
+// otherwise logs in again. It is called without holding the lock.
+func (p *podAuthenticator) refresh(ctx context.Context, namespace, serviceAccount, current string, renewable bool) (*podToken, error) {
+	key := fmt.Sprintf("%s/%s", namespace, serviceAccount)
+
+	if current != "" && renewable {
+		expiry, renewable, err := p.renew(current)
+		if err != nil {
+			klog.Warningf("unable to renew vault token for %s: %v", key, err)
+		}
+
+		t := &podToken{key: key, token: current, expiry: expiry, renewable: renewable}
+		if err == nil && t.live() {
+			return t, nil
+		}
+	}
+
+	return p.login(ctx, namespace, serviceAccount)
+}
+
+// login exchanges a service account token for a Vault token.
+func (p *podAuthenticator) login(ctx context.Context, namespace, serviceAccount string) (*podToken, error) {
+	var role bytes.Buffer
+	err := p.role.Execute(&role, struct {
+		Namespace      string
+		ServiceAccount string
+	}{
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	jwt, err := p.kube.CreateServiceAccountToken(ctx, namespace, serviceAccount, p.config.Audiences, podJWTExpiration)
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain token for %s/%s: %v", namespace, serviceAccount, err)
+	}
+
+	klog.Infof("logging in to vault as %s/%s with role %s", namespace, serviceAccount, role.String())
+
+	vc, err := cloneClient(p.vault)
+	if err != nil {
+		return nil, err
+	}
+	vc.ClearToken()
+
+	loginPath := fmt.Sprintf("auth/%s/login", p.config.MountPath)
+	secret, err := vc.Logical().Write(loginPath, map[string]interface{}{
+		"role": role.String(),
+		"jwt":  jwt,
+	})
+	if err != nil {
+		return nil, vaultError(err, fmt.Sprintf("%s (role %s)", loginPath, role.String()))
+	}
+
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("failure: no token returned from vault")
+	}
+
+	return &podToken{
+		key:       fmt.Sprintf("%s/%s", namespace, serviceAccount),
+		token:     secret.Auth.ClientToken,
+		expiry:    time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second),
+		renewable: secret.Auth.Renewable,
+	}, nil
+}
+
+// renew extends the lifetime of the token, up to its maximum TTL,
+// and returns its new expiry and whether it may be renewed again.
+func (p *podAuthenticator) renew(token string) (time.Time, bool, error) {
+	vc, err := cloneClient(p.vault)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	vc.SetToken(token)
+
+	secret, err := vc.Auth().Token().RenewSelf(0)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	if secret == nil || secret.Auth == nil {
+		return time.Time{}, false, fmt.Errorf("failure: no token returned from vault")
+	}
+
+	return time.Now().Add(time.Duration(secret.Auth.LeaseDuration) * time.Second), secret.Auth.Renewable, nil
+}
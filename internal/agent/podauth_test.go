@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/StatCan/boathouse/internal/kube"
+	vault "github.com/hashicorp/vault/api"
+)
+
+// testPodAuthenticator returns an authenticator logging in to a fake
+// Kubernetes API and Vault. Token requests for the blocked namespace
+// wait until unblock is closed.
+func testPodAuthenticator(t *testing.T, blocked string, unblock chan struct{}) (*podAuthenticator, *int32, func()) {
+	var logins int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/namespaces/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/v1/namespaces/"+blocked+"/") {
+			<-unblock
+		}
+		w.Write([]byte(`{"status": {"token": "jwt"}}`))
+	})
+	mux.HandleFunc("/v1/auth/kubernetes/login", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&logins, 1)
+
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"auth": {"client_token": "token-` + body["role"] + `", "lease_duration": 3600, "renewable": true}}`))
+	})
+	srv := httptest.NewServer(mux)
+
+	dir, err := ioutil.TempDir("", "podauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("agent"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	vc, err := vault.NewClient(&vault.Config{Address: srv.URL, HttpClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &podAuthenticator{
+		config: PodAuthConfig{MountPath: defaultPodAuthMountPath},
+		role:   template.Must(template.New("role").Parse(defaultPodAuthRole)),
+		vault:  vc,
+		kube:   kube.NewClient(srv.URL, tokenFile, srv.Client()),
+		tokens: map[string]*podToken{},
+		logins: map[string]*podLogin{},
+		leases: map[string]*podLease{},
+	}
+
+	return p, &logins, func() {
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPodAuthenticatorLoginsDoNotBlockOtherPods(t *testing.T) {
+	unblock := make(chan struct{})
+	p, _, cleanup := testPodAuthenticator(t, "slow", unblock)
+	defer cleanup()
+	defer close(unblock)
+
+	go p.token(context.Background(), "slow", "default")
+
+	// Let the slow login start
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan string)
+	go func() {
+		token, err := p.token(context.Background(), "team-a", "default")
+		if err != nil {
+			t.Errorf("token: %v", err)
+		}
+		done <- token
+	}()
+
+	select {
+	case token := <-done:
+		if token != "token-team-a-default" {
+			t.Errorf("got token %q, want token-team-a-default", token)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("login of team-a waited for the login of another pod")
+	}
+}
+
+func TestPodAuthenticatorSharesLogins(t *testing.T) {
+	unblock := make(chan struct{})
+	p, logins, cleanup := testPodAuthenticator(t, "team-a", unblock)
+	defer cleanup()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if token, err := p.token(context.Background(), "team-a", "default"); err != nil || token != "token-team-a-default" {
+				t.Errorf("got %q, %v, want token-team-a-default", token, err)
+			}
+		}()
+	}
+
+	// Let the requests queue behind the first login
+	time.Sleep(50 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	if *logins != 1 {
+		t.Errorf("logged in %d times, want 1", *logins)
+	}
+
+	// The token is cached once logged in
+	if _, err := p.token(context.Background(), "team-a", "default"); err != nil || *logins != 1 {
+		t.Errorf("logged in %d times, %v, want the cached token", *logins, err)
+	}
+}
@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Policy restricts the Vault paths which pods may request credentials for.
+type Policy struct {
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule allows pods in the namespaces, or running as the service accounts,
+// to request credentials for the paths. All values may be globs.
+type PolicyRule struct {
+	// Namespaces the rule applies to. (e.g., team-*)
+	Namespaces []string `yaml:"namespaces"`
+
+	// ServiceAccounts the rule applies to, as namespace/name. (e.g., team-a/default)
+	ServiceAccounts []string `yaml:"serviceAccounts"`
+
+	// Paths are the Vault paths which may be requested. (e.g., minio/keys/team-a*)
+	Paths []string `yaml:"paths"`
+
+	// Targets are the providers, or Vault targets, the paths may be
+	// requested from. By default, only the default provider.
+	Targets []string `yaml:"targets"`
+}
+
+// ForbiddenError is returned when the policy does not allow a request.
+type ForbiddenError struct {
+	Message string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
+// LoadPolicy reads a policy file.
+func LoadPolicy(filename string) (*Policy, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err = yaml.UnmarshalStrict(b, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %v", filename, err)
+	}
+
+	// Check the globs, so that bad patterns are not silently ignored
+	for i, rule := range policy.Rules {
+		for _, patterns := range [][]string{rule.Namespaces, rule.ServiceAccounts, rule.Paths, rule.Targets} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("policy rule %d: invalid pattern %q: %v", i, pattern, err)
+				}
+			}
+		}
+	}
+
+	return &policy, nil
+}
+
+// Authorize checks that the pod making the request may read the requested
+// path from the requested target. The target must be resolved, with
+// defaultTarget naming the default provider.
+func (p *Policy) Authorize(req IssueCredentialRequest, defaultTarget string) error {
+	if req.Namespace == "" {
+		return &ForbiddenError{
+			Message: fmt.Sprintf("permission denied on %s: the requesting pod is unknown", req.Path),
+		}
+	}
+
+	serviceAccount := fmt.Sprintf("%s/%s", req.Namespace, req.ServiceAccount)
+
+	for _, rule := range p.Rules {
+		if !matchAny(rule.Namespaces, req.Namespace) && !(req.ServiceAccount != "" && matchAny(rule.ServiceAccounts, serviceAccount)) {
+			continue
+		}
+
+		if !matchAny(rule.Paths, req.Path) {
+			continue
+		}
+
+		if (len(rule.Targets) == 0 && req.Target == defaultTarget) || matchAny(rule.Targets, req.Target) {
+			return nil
+		}
+	}
+
+	return &ForbiddenError{
+		Message: fmt.Sprintf("permission denied on %s from %s: not allowed for service account %s", req.Path, req.Target, serviceAccount),
+	}
+}
+
+// matchAny reports whether name matches any of the patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy := &Policy{
+		Rules: []PolicyRule{
+			{
+				Namespaces:      []string{"team-*"},
+				ServiceAccounts: []string{"shared/reader"},
+				Paths:           []string{"minio/keys/team-a*"},
+			},
+			{
+				ServiceAccounts: []string{"ops/*"},
+				Paths:           []string{"minio/keys/*"},
+			},
+			{
+				Namespaces: []string{"team-a"},
+				Paths:      []string{"backup/*"},
+				Targets:    []string{"backup-*"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		req     IssueCredentialRequest
+		allowed bool
+	}{
+		{
+			name:    "namespace glob",
+			req:     IssueCredentialRequest{Path: "minio/keys/team-a", PodIdentity: PodIdentity{Namespace: "team-a"}},
+			allowed: true,
+		},
+		{
+			name:    "path glob",
+			req:     IssueCredentialRequest{Path: "minio/keys/team-a-readonly", PodIdentity: PodIdentity{Namespace: "team-b"}},
+			allowed: true,
+		},
+		{
+			name: "path not matched",
+			req:  IssueCredentialRequest{Path: "minio/keys/team-b", PodIdentity: PodIdentity{Namespace: "team-b"}},
+		},
+		{
+			name: "glob does not cross path separators",
+			req:  IssueCredentialRequest{Path: "minio/keys/team-a/admin", PodIdentity: PodIdentity{Namespace: "team-a"}},
+		},
+		{
+			name: "namespace not matched",
+			req:  IssueCredentialRequest{Path: "minio/keys/team-a", PodIdentity: PodIdentity{Namespace: "default"}},
+		},
+		{
+			name:    "service account",
+			req:     IssueCredentialRequest{Path: "minio/keys/team-a", PodIdentity: PodIdentity{Namespace: "shared", ServiceAccount: "reader"}},
+			allowed: true,
+		},
+		{
+			name: "other service account of the namespace",
+			req:  IssueCredentialRequest{Path: "minio/keys/team-a", PodIdentity: PodIdentity{Namespace: "shared", ServiceAccount: "writer"}},
+		},
+		{
+			name:    "service account glob",
+			req:     IssueCredentialRequest{Path: "minio/keys/team-b", PodIdentity: PodIdentity{Namespace: "ops", ServiceAccount: "backup"}},
+			allowed: true,
+		},
+		{
+			name: "service account glob without a service account",
+			req:  IssueCredentialRequest{Path: "minio/keys/team-b", PodIdentity: PodIdentity{Namespace: "ops"}},
+		},
+		{
+			name: "missing namespace",
+			req:  IssueCredentialRequest{Path: "minio/keys/team-a", PodIdentity: PodIdentity{ServiceAccount: "reader"}},
+		},
+		{
+			name: "rule without targets from another target",
+			req:  IssueCredentialRequest{Path: "minio/keys/team-a", Target: "backup-east", PodIdentity: PodIdentity{Namespace: "team-a"}},
+		},
+		{
+			name:    "target glob",
+			req:     IssueCredentialRequest{Path: "backup/team-a", Target: "backup-east", PodIdentity: PodIdentity{Namespace: "team-a"}},
+			allowed: true,
+		},
+		{
+			name: "rule with targets from the default target",
+			req:  IssueCredentialRequest{Path: "backup/team-a", Target: DefaultTarget, PodIdentity: PodIdentity{Namespace: "team-a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.req.Target == "" {
+				tt.req.Target = DefaultTarget
+			}
+
+			err := policy.Authorize(tt.req, DefaultTarget)
+			if tt.allowed && err != nil {
+				t.Errorf("got %v, want allowed", err)
+			}
+			if !tt.allowed {
+				if _, ok := err.(*ForbiddenError); !ok {
+					t.Errorf("got %v, want a ForbiddenError", err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{name: "valid", policy: "rules:\n  - namespaces: [team-*]\n    paths: [minio/keys/*]\n"},
+		{name: "invalid glob", policy: "rules:\n  - namespaces: [\"team-[\"]\n    paths: [minio/keys/*]\n", wantErr: true},
+		{name: "unknown field", policy: "rules:\n  - namespace: [team-a]\n", wantErr: true},
+	}
+
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(dir, "policy.yaml")
+			if err := ioutil.WriteFile(filename, []byte(tt.policy), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadPolicy(filename)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
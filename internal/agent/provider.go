@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Types of credential providers.
+const (
+	ProviderStatic     = "static"
+	ProviderKubernetes = "kubernetes"
+)
+
+// CredentialProvider issues credentials, and manages their leases.
+// The Vault targets are providers, along with those configured.
+type CredentialProvider interface {
+	// Name identifies the provider in requests and leases.
+	Name() string
+
+	// PathPrefixes are the paths served by the provider.
+	PathPrefixes() []string
+
+	// Issue issues the requested credentials.
+	Issue(ctx context.Context, req IssueCredentialRequest) (*IssueCredentialResponse, error)
+
+	// Renew extends a lease, or returns ErrLeaseNotRenewable
+	// once new credentials must be issued instead.
+	Renew(ctx context.Context, req RenewLeaseRequest) (*Lease, error)
+
+	// Revoke revokes a lease, so that its credentials are no longer usable.
+	Revoke(ctx context.Context, req RevokeLeaseRequest) error
+
+	// Checks report whether the provider is ready to issue credentials.
+	Checks() []HealthCheck
+}
+
+// podScoped is implemented by providers whose credentials depend on
+// the requesting pod, and so may not be shared with other pods.
+type podScoped interface {
+	podScoped() bool
+}
+
+// ProviderConfig configures a credential provider other than Vault.
+type ProviderConfig struct {
+	// Name is used to select the provider with the provider option.
+	Name string `mapstructure:"name"`
+
+	// Type of the provider: static or kubernetes.
+	Type string `mapstructure:"type"`
+
+	// PathPrefixes are the paths served by the provider.
+	PathPrefixes []string `mapstructure:"pathPrefixes"`
+
+	// RefreshInterval is how often credentials are re-read,
+	// unless a TTL is requested.
+	RefreshInterval time.Duration `mapstructure:"refreshInterval"`
+
+	// File holds the credentials of a static provider, by path.
+	File string `mapstructure:"file"`
+
+	// Namespace holds the secrets of a kubernetes provider.
+	// By default, secrets are read from the namespace of the requesting pod.
+	Namespace string `mapstructure:"namespace"`
+}
+
+// validateProviders checks that providers are named uniquely,
+// and that the default provider exists.
+func validateProviders(providers []ProviderConfig, targets []TargetConfig, def string) error {
+	names := map[string]bool{DefaultTarget: true}
+	for _, t := range targets {
+		names[t.Name] = true
+	}
+
+	for _, p := range providers {
+		if p.Name == "" {
+			return fmt.Errorf("providers: name is required")
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("providers: duplicate provider %q", p.Name)
+		}
+		names[p.Name] = true
+
+		switch p.Type {
+		case ProviderStatic:
+			if p.File == "" {
+				return fmt.Errorf("provider %s: file is required", p.Name)
+			}
+		case ProviderKubernetes:
+		default:
+			return fmt.Errorf("provider %s: unknown type %q", p.Name, p.Type)
+		}
+	}
+
+	if def != "" && !names[def] {
+		return fmt.Errorf("defaultProvider: unknown provider %q", def)
+	}
+
+	return nil
+}
+
+// newProvider creates a configured provider.
+func (a *Agent) newProvider(config ProviderConfig) (CredentialProvider, error) {
+	if config.RefreshInterval == 0 {
+		config.RefreshInterval = defaultKVRefreshInterval
+	}
+
+	switch config.Type {
+	case ProviderStatic:
+		return newFileProvider(config, a.fieldsForPath), nil
+	case ProviderKubernetes:
+		return newSecretProvider(config, a.fieldsForPath)
+	default:
+		return nil, fmt.Errorf("provider %s: unknown type %q", config.Name, config.Type)
+	}
+}
+
+// provider returns the provider of the request: the one named, or otherwise
+// the provider with the longest prefix of path, or the default provider.
+// Lease IDs begin with the path of their secret, and so route the same way.
+func (a *Agent) provider(name, path string) (CredentialProvider, error) {
+	if name != "" {
+		for _, p := range a.providers {
+			if p.Name() == name {
+				return p, nil
+			}
+		}
+
+		return nil, newError(http.StatusBadRequest, ReasonBadRequest, "unknown provider %q (configured: %s)", name, strings.Join(a.providerNames(), ", "))
+	}
+
+	match := a.defaultProvider
+	length := -1
+	for _, p := range a.providers {
+		for _, prefix := range p.PathPrefixes() {
+			if strings.HasPrefix(path, prefix) && len(prefix) > length {
+				match = p
+				length = len(prefix)
+			}
+		}
+	}
+
+	if match == nil {
+		return nil, newError(http.StatusNotFound, ReasonNotFound, "no provider serves %s", path)
+	}
+
+	return match, nil
+}
+
+// providerNames returns the names of the configured providers.
+func (a *Agent) providerNames() []string {
+	names := make([]string, 0, len(a.providers))
+	for _, p := range a.providers {
+		names = append(names, p.Name())
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// staticLease returns the synthetic lease of credentials without a lease,
+// which expires when they should be re-read. A requested TTL overrides
+// the refresh interval.
+func staticLease(provider string, ttl, refresh time.Duration) Lease {
+	if ttl != 0 {
+		refresh = ttl
+	}
+	if refresh == 0 {
+		refresh = defaultKVRefreshInterval
+	}
+
+	return Lease{
+		Expiry: time.Now().Add(refresh),
+		Static: true,
+		Target: provider,
+	}
+}
+
+// trimPrefix removes the longest of prefixes from path.
+func trimPrefix(path string, prefixes []string) string {
+	longest := ""
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+
+	return strings.TrimPrefix(path, longest)
+}
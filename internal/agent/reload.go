@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"reflect"
+
+	"k8s.io/klog"
+)
+
+// settings returns the current configuration of the agent.
+func (a *Agent) settings() Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.config
+}
+
+// currentPolicy returns the current policy, or nil if there is none.
+func (a *Agent) currentPolicy() *Policy {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.policy
+}
+
+// Reload applies a new configuration to the running agent. The field
+// mappings, KV refresh interval, cache, policy and audit log are reloaded.
+// Changes to the providers, Vault targets and authentication require a restart.
+func (a *Agent) Reload(config Config) error {
+	if err := validateFieldMappings(config.Fields); err != nil {
+		return err
+	}
+
+	var policy *Policy
+	if config.PolicyFile != "" {
+		var err error
+		if policy, err = LoadPolicy(config.PolicyFile); err != nil {
+			return err
+		}
+	}
+
+	if err := a.audit.reopen(config.Audit); err != nil {
+		return err
+	}
+
+	a.cache.reconfigure(config.Cache)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !reflect.DeepEqual(a.config.Auth, config.Auth) ||
+		!reflect.DeepEqual(a.config.PodAuth, config.PodAuth) ||
+		!reflect.DeepEqual(a.config.Targets, config.Targets) ||
+		!reflect.DeepEqual(a.config.Providers, config.Providers) ||
+		a.config.DefaultProvider != config.DefaultProvider {
+		klog.Warningf("changes to auth, podAuth, targets and providers require the agent to be restarted")
+
+		// Keep describing the running configuration
+		config.Auth = a.config.Auth
+		config.PodAuth = a.config.PodAuth
+		config.Targets = a.config.Targets
+		config.Providers = a.config.Providers
+		config.DefaultProvider = a.config.DefaultProvider
+	}
+
+	a.config = config
+	a.policy = policy
+
+	klog.Infof("reloaded agent configuration")
+	return nil
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"net/http"
+
+	"github.com/StatCan/boathouse/pkg/api"
+	"github.com/gorilla/mux"
+)
+
+// RegisterRoutes registers the agent's API on the router. The
+// unversioned routes are kept for drivers of earlier releases,
+// whose mount daemons outlive upgrades of the agent.
+func (a *Agent) RegisterRoutes(router *mux.Router) {
+	router.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
+
+	// Version 1
+	router.Path(api.PathIssue).Methods(http.MethodPost).HandlerFunc(a.HandleIssueCredentials)
+	router.Path(api.PathRenew).Methods(http.MethodPost).HandlerFunc(a.HandleRenewLease)
+	router.Path(api.PathRevoke).Methods(http.MethodPost).HandlerFunc(a.HandleRevokeLease)
+	router.Path(api.PathLeases).Methods(http.MethodGet).HandlerFunc(a.HandleListLeases)
+	router.Path(api.PathToken).Methods(http.MethodGet).HandlerFunc(a.HandleTokenHealth)
+	router.Path(api.PathHealthz).Methods(http.MethodGet).HandlerFunc(a.HandleHealthz)
+	router.Path(api.PathReadyz).Methods(http.MethodGet).HandlerFunc(a.HandleReadyz)
+
+	// Unversioned
+	router.Path("/").Methods(http.MethodGet).HandlerFunc(a.HandleHealthz)
+	router.Path("/healthz").Methods(http.MethodGet).HandlerFunc(a.HandleHealthz)
+	router.Path("/readyz").Methods(http.MethodGet).HandlerFunc(a.HandleReadyz)
+	router.Path("/issue").Methods(http.MethodPost).HandlerFunc(a.HandleIssueCredentials)
+	router.Path("/renew").Methods(http.MethodPost).HandlerFunc(a.HandleRenewLease)
+	router.Path("/revoke").Methods(http.MethodPost).HandlerFunc(a.HandleRevokeLease)
+	router.Path("/token").Methods(http.MethodGet).HandlerFunc(a.HandleTokenHealth)
+}
+
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, newError(http.StatusNotFound, ReasonNotFound, "no such route %s", r.URL.Path))
+}
+
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, newError(http.StatusMethodNotAllowed, ReasonMethodNotAllowed, "method %s is not allowed on %s", r.Method, r.URL.Path))
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/StatCan/boathouse/internal/kube"
+	"k8s.io/klog"
+)
+
+// secretProvider issues static credentials read from Kubernetes Secrets.
+// The name of the secret is the requested path, less the provider's prefix.
+type secretProvider struct {
+	name      string
+	prefixes  []string
+	namespace string
+	refresh   time.Duration
+	fields    func(path string) map[string]Field
+	kube      *kube.Client
+}
+
+func newSecretProvider(config ProviderConfig, fields func(path string) map[string]Field) (*secretProvider, error) {
+	kc, err := kube.NewInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &secretProvider{
+		name:      config.Name,
+		prefixes:  config.PathPrefixes,
+		namespace: config.Namespace,
+		refresh:   config.RefreshInterval,
+		fields:    fields,
+		kube:      kc,
+	}, nil
+}
+
+func (p *secretProvider) Name() string {
+	return p.name
+}
+
+func (p *secretProvider) PathPrefixes() []string {
+	return p.prefixes
+}
+
+func (p *secretProvider) Issue(ctx context.Context, req IssueCredentialRequest) (*IssueCredentialResponse, error) {
+	if req.PublicKey != "" || req.Engine != "" {
+		return nil, newError(http.StatusBadRequest, ReasonBadRequest, "provider %s only issues static credentials", p.name)
+	}
+
+	name := trimPrefix(req.Path, p.prefixes)
+	if !kube.IsDNS1123Subdomain(name) {
+		return nil, newError(http.StatusBadRequest, ReasonBadRequest, "invalid secret name %q in path %s", name, req.Path)
+	}
+
+	// Pods may only read the secrets of their own namespace,
+	// unless the provider reads those of a shared namespace
+	namespace := p.namespace
+	if namespace == "" {
+		namespace = req.Namespace
+	}
+	if namespace == "" {
+		return nil, newError(http.StatusForbidden, ReasonPermissionDenied, "permission denied on %s: the requesting pod is unknown", req.Path)
+	}
+
+	klog.Infof("reading static credentials: secret %s/%s from %s", namespace, name, p.name)
+
+	secret, err := p.kube.GetSecret(ctx, namespace, name)
+	if err != nil {
+		klog.Warningf("unable to read secret %s/%s: %v", namespace, name, err)
+		return nil, secretError(err, req.Path)
+	}
+
+	data := make(map[string]interface{}, len(secret))
+	for key, val := range secret {
+		data[key] = string(val)
+	}
+
+	response := IssueCredentialResponse{
+		Lease: staticLease(p.name, req.TTL, p.refresh),
+	}
+
+	if err := extractFields(req.Path, p.fields(req.Path), data, &response); err != nil {
+		klog.Warningf("unable to extract credentials at %s: %v", req.Path, err)
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+func (p *secretProvider) Renew(ctx context.Context, req RenewLeaseRequest) (*Lease, error) {
+	return nil, ErrLeaseNotRenewable
+}
+
+// Revoke does nothing, as static credentials cannot be revoked.
+func (p *secretProvider) Revoke(ctx context.Context, req RevokeLeaseRequest) error {
+	return nil
+}
+
+// Checks reports nothing, as the provider's permissions
+// can only be checked against the secrets requested.
+func (p *secretProvider) Checks() []HealthCheck {
+	return nil
+}
+
+// podScoped reports whether secrets are read from the namespace of
+// the requesting pod, so that they are not shared with other namespaces.
+func (p *secretProvider) podScoped() bool {
+	return p.namespace == ""
+}
+
+// secretError converts an error reading the secret at path into an Error.
+func secretError(err error, path string) error {
+	serr, ok := err.(*kube.StatusError)
+	if !ok {
+		return newError(http.StatusServiceUnavailable, ReasonUnavailable, "unable to reach kubernetes: %v", err)
+	}
+
+	switch serr.Code {
+	case http.StatusNotFound:
+		return newError(http.StatusNotFound, ReasonNotFound, "no such path %s", path)
+	case http.StatusForbidden:
+		return newError(http.StatusForbidden, ReasonPermissionDenied, "permission denied on %s", path)
+	default:
+		return newError(http.StatusBadGateway, ReasonInternal, "%v", serr)
+	}
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"golang.org/x/crypto/ssh"
+	"k8s.io/klog"
+)
+
+// SignPublicKey signs the public key in the request
+// using the Vault SSH secrets engine.
+func (a *Agent) SignPublicKey(ctx context.Context, vc *vault.Client, req IssueCredentialRequest) (*IssueCredentialResponse, error) {
+	klog.Infof("signing public key: %s with TTL %v", req.Path, req.TTL)
+
+	data := map[string]interface{}{
+		"public_key": req.PublicKey,
+		"cert_type":  "user",
+	}
+
+	if req.ValidPrincipals != "" {
+		data["valid_principals"] = req.ValidPrincipals
+	}
+
+	if req.TTL != 0 {
+		data["ttl"] = strconv.FormatInt(int64(req.TTL.Seconds()), 10)
+	}
+
+	secret, err := vc.Logical().Write(req.Path, data)
+	if err != nil {
+		klog.Warningf("unable to sign public key at %s: %v", req.Path, err)
+		return nil, vaultError(err, req.Path)
+	}
+
+	if secret == nil {
+		return nil, notFound(req.Path)
+	}
+
+	signedKey := stringField(secret.Data, "signed_key")
+	if signedKey == "" {
+		return nil, fmt.Errorf("failure: no signed key returned from vault")
+	}
+
+	// Signed certificates have no lease, so the certificate's
+	// validity determines when it must be signed again
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signedKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signed key: %v", err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("signed key is not a certificate")
+	}
+
+	response := IssueCredentialResponse{
+		Lease: Lease{
+			ID:     secret.LeaseID,
+			Expiry: time.Unix(int64(cert.ValidBefore), 0),
+			Target: req.Target,
+		},
+		Certificate: signedKey,
+	}
+
+	klog.Infof("signed public key: serial %d, expiring at %v", cert.Serial, response.Lease.Expiry)
+
+	return &response, nil
+}
@@ -1,39 +1,190 @@
 package agent
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/StatCan/boathouse/pkg/api"
 	vault "github.com/hashicorp/vault/api"
 )
 
 // Agent is an agent.
 type Agent struct {
-	vault *vault.Client
+	cache   *leaseCache
+	targets []*target
+	audit   *auditLog
+
+	// providers include the Vault targets
+	providers       []CredentialProvider
+	defaultProvider CredentialProvider
+
+	// mu guards the settings which may be reloaded
+	mu     sync.RWMutex
+	config Config
+	policy *Policy
+
+	// listening is set once the agent's listener is bound
+	listening int32
 }
 
-// NewAgent generates a new Boathouse agent.
-func NewAgent(vault *vault.Client) (*Agent, error) {
-	return &Agent{
-		vault: vault,
-	}, nil
+// Config is the configuration of the agent.
+type Config struct {
+	// Fields maps the data of secrets to credential fields, by Vault path prefix.
+	Fields []FieldMapping `mapstructure:"fields"`
+
+	// KVRefreshInterval is how often static secrets are re-read,
+	// unless a TTL is requested.
+	KVRefreshInterval time.Duration `mapstructure:"kvRefreshInterval"`
+
+	// Cache configures the sharing of leases between mounts.
+	Cache CacheConfig `mapstructure:"cache"`
+
+	// PolicyFile restricts the Vault paths pods may request.
+	// Without a policy, any path may be requested.
+	PolicyFile string `mapstructure:"policyFile"`
+
+	// PodAuth configures logging in to Vault as the requesting pod.
+	PodAuth PodAuthConfig `mapstructure:"podAuth"`
+
+	// Auth configures how the agent authenticates to Vault.
+	Auth AuthConfig `mapstructure:"auth"`
+
+	// Audit configures the audit log of issued credentials.
+	Audit AuditConfig `mapstructure:"audit"`
+
+	// Targets are additional Vault clusters or namespaces,
+	// selected by path prefix or by name.
+	Targets []TargetConfig `mapstructure:"targets"`
+
+	// Providers are credential providers other than Vault,
+	// selected by path prefix or by name.
+	Providers []ProviderConfig `mapstructure:"providers"`
+
+	// DefaultProvider serves the paths of no other provider.
+	// By default, the default Vault target. Naming another
+	// provider allows the agent to run without Vault.
+	DefaultProvider string `mapstructure:"defaultProvider"`
 }
 
-// IssueCredentialRequest represents a request for credentials.
-type IssueCredentialRequest struct {
-	// Path is the Vault path
-	Path string `json:"path"`
+// NewAgent generates a new Boathouse agent.
+func NewAgent(vault *vault.Client, config Config) (*Agent, error) {
+	if err := validateFieldMappings(config.Fields); err != nil {
+		return nil, err
+	}
+
+	if err := config.Auth.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := validateTargets(config.Targets); err != nil {
+		return nil, err
+	}
 
-	// TTL is the requested time
-	TTL time.Duration `json:"ttl"`
+	if err := validateProviders(config.Providers, config.Targets, config.DefaultProvider); err != nil {
+		return nil, err
+	}
+
+	var policy *Policy
+	if config.PolicyFile != "" {
+		var err error
+		if policy, err = LoadPolicy(config.PolicyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	audit, err := newAuditLog(config.Audit)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Agent{
+		config: config,
+		cache:  newLeaseCache(config.Cache, config.PodAuth.Enabled),
+		policy: policy,
+		audit:  audit,
+	}
+
+	// The default target is only used when Vault serves the default provider
+	if config.DefaultProvider == "" || config.DefaultProvider == DefaultTarget {
+		def, err := newTarget(DefaultTarget, nil, vault, config.Auth, config.PodAuth)
+		if err != nil {
+			return nil, err
+		}
+		a.targets = append(a.targets, def)
+	}
+
+	for _, tconfig := range config.Targets {
+		vc, err := newTargetClient(tconfig)
+		if err != nil {
+			return nil, err
+		}
+
+		t, err := newTarget(tconfig.Name, tconfig.PathPrefixes, vc, tconfig.Auth, config.PodAuth)
+		if err != nil {
+			return nil, err
+		}
+		a.targets = append(a.targets, t)
+	}
+
+	for _, t := range a.targets {
+		a.providers = append(a.providers, &vaultProvider{target: t, agent: a})
+	}
+
+	for _, pconfig := range config.Providers {
+		p, err := a.newProvider(pconfig)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %v", pconfig.Name, err)
+		}
+		a.providers = append(a.providers, p)
+	}
+
+	def := config.DefaultProvider
+	if def == "" {
+		def = DefaultTarget
+	}
+	if a.defaultProvider, err = a.provider(def, ""); err != nil {
+		return nil, err
+	}
+
+	return a, nil
 }
 
-type Lease struct {
-	ID     string    `json:"id"`
-	Expiry time.Time `json:"expiry"`
+// Close releases the resources held by the agent.
+func (a *Agent) Close() error {
+	return a.audit.Close()
 }
 
-type IssueCredentialResponse struct {
-	Lease     Lease  `json:"lease"`
-	AccessKey string `json:"access_key"`
-	SecretKey string `json:"secret_key"`
+// Start authenticates the agent to each Vault target,
+// and keeps its tokens, and those of pods holding leases, alive until ctx is done.
+func (a *Agent) Start(ctx context.Context) error {
+	for _, t := range a.targets {
+		if t.pods != nil {
+			t.pods.Start(ctx)
+		}
+
+		if t.auth == nil {
+			continue
+		}
+
+		if err := t.auth.Start(ctx); err != nil {
+			return fmt.Errorf("target %s: %v", t.name, err)
+		}
+	}
+
+	return nil
 }
+
+// Types of the agent's API, shared with its clients.
+type (
+	IssueCredentialRequest  = api.IssueCredentialRequest
+	IssueCredentialResponse = api.IssueCredentialResponse
+	PodIdentity             = api.PodIdentity
+	Lease                   = api.Lease
+	RenewLeaseRequest       = api.RenewLeaseRequest
+	RenewLeaseResponse      = api.RenewLeaseResponse
+	RevokeLeaseRequest      = api.RevokeLeaseRequest
+	HeldLease               = api.HeldLease
+	LeaseList               = api.LeaseList
+)
@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// DefaultTarget is the name of the Vault target configured
+// from the agent's environment (VAULT_ADDR, VAULT_TOKEN, ...).
+const DefaultTarget = "default"
+
+// TargetConfig configures an additional Vault cluster, or namespace.
+type TargetConfig struct {
+	// Name is used to select the target with the vault-target option.
+	Name string `mapstructure:"name"`
+
+	// Address of the Vault server.
+	Address string `mapstructure:"address"`
+
+	// Namespace is the Vault Enterprise namespace.
+	Namespace string `mapstructure:"namespace"`
+
+	// PathPrefixes are the Vault paths served by the target.
+	PathPrefixes []string `mapstructure:"pathPrefixes"`
+
+	// TLS configures the connection to the Vault server.
+	TLS TLSConfig `mapstructure:"tls"`
+
+	// Auth configures how the agent authenticates to the target.
+	Auth AuthConfig `mapstructure:"auth"`
+}
+
+// TLSConfig configures the TLS connection to a Vault server.
+type TLSConfig struct {
+	CACert     string `mapstructure:"caCert"`
+	CAPath     string `mapstructure:"caPath"`
+	ClientCert string `mapstructure:"clientCert"`
+	ClientKey  string `mapstructure:"clientKey"`
+	ServerName string `mapstructure:"serverName"`
+	Insecure   bool   `mapstructure:"insecure"`
+}
+
+// target is a Vault cluster or namespace credentials are issued from.
+type target struct {
+	name     string
+	prefixes []string
+	vault    *vault.Client
+	auth     *tokenManager
+	pods     *podAuthenticator
+}
+
+// newTarget creates a target using the Vault client.
+func newTarget(name string, prefixes []string, vc *vault.Client, auth AuthConfig, podAuth PodAuthConfig) (*target, error) {
+	t := &target{
+		name:     name,
+		prefixes: prefixes,
+		vault:    vc,
+	}
+
+	if auth.Method != "" {
+		t.auth = newTokenManager(auth, vc)
+	}
+
+	if podAuth.Enabled {
+		var err error
+		if t.pods, err = newPodAuthenticator(podAuth, vc); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// newTargetClient creates a Vault client for a configured target.
+func newTargetClient(config TargetConfig) (*vault.Client, error) {
+	vconfig := &vault.Config{
+		Address: config.Address,
+	}
+
+	err := vconfig.ConfigureTLS(&vault.TLSConfig{
+		CACert:        config.TLS.CACert,
+		CAPath:        config.TLS.CAPath,
+		ClientCert:    config.TLS.ClientCert,
+		ClientKey:     config.TLS.ClientKey,
+		TLSServerName: config.TLS.ServerName,
+		Insecure:      config.TLS.Insecure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("target %s: failed to configure tls: %v", config.Name, err)
+	}
+
+	vc, err := vault.NewClient(vconfig)
+	if err != nil {
+		return nil, fmt.Errorf("target %s: failed to create vault client: %v", config.Name, err)
+	}
+
+	// The environment's token and namespace belong to the default target
+	vc.ClearToken()
+	vc.SetNamespace(config.Namespace)
+
+	return vc, nil
+}
+
+// validateTargets checks that targets are named uniquely,
+// and are able to authenticate to Vault.
+func validateTargets(targets []TargetConfig) error {
+	names := map[string]bool{DefaultTarget: true}
+
+	for _, t := range targets {
+		if t.Name == "" {
+			return fmt.Errorf("targets: name is required")
+		}
+
+		if names[t.Name] {
+			return fmt.Errorf("targets: duplicate target %q", t.Name)
+		}
+		names[t.Name] = true
+
+		if t.Address == "" {
+			return fmt.Errorf("target %s: address is required", t.Name)
+		}
+
+		if t.Auth.Method == "" {
+			return fmt.Errorf("target %s: an auth method is required", t.Name)
+		}
+
+		if err := t.Auth.validate(); err != nil {
+			return fmt.Errorf("target %s: %v", t.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// cloneClient copies a Vault client, keeping its namespace,
+// which Clone does not.
+func cloneClient(vc *vault.Client) (*vault.Client, error) {
+	clone, err := vc.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	clone.SetHeaders(vc.Headers())
+	return clone, nil
+}
+
+// vaultFor returns the Vault client used to issue the requested credentials.
+func (t *target) vaultFor(ctx context.Context, req IssueCredentialRequest) (*vault.Client, error) {
+	if t.pods == nil {
+		return t.vault, nil
+	}
+
+	return t.pods.client(ctx, req.Namespace, req.ServiceAccount)
+}
+
+// refused notifies the token manager when Vault refuses the agent's token.
+func (t *target) refused(err error) {
+	if t.auth != nil && isPermissionDenied(err) {
+		t.auth.Refused()
+	}
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// vaultProvider issues credentials from a Vault target.
+type vaultProvider struct {
+	*target
+	agent *Agent
+}
+
+func (p *vaultProvider) Name() string {
+	return p.name
+}
+
+func (p *vaultProvider) PathPrefixes() []string {
+	return p.prefixes
+}
+
+func (p *vaultProvider) Issue(ctx context.Context, req IssueCredentialRequest) (*IssueCredentialResponse, error) {
+	return p.agent.issueCredentials(ctx, p.target, req)
+}
+
+// Renew renews a lease, provided it is renewable
+// and has not reached its maximum TTL. Leases issued to
+// pods are renewed with their token, as the agent may have none.
+func (p *vaultProvider) Renew(ctx context.Context, req RenewLeaseRequest) (*Lease, error) {
+	vc, err := p.vaultFor(ctx, IssueCredentialRequest{PodIdentity: req.PodIdentity})
+	if err != nil {
+		return nil, err
+	}
+
+	lookup, err := vc.Logical().Write("sys/leases/lookup", map[string]interface{}{
+		"lease_id": req.LeaseID,
+	})
+	if err != nil {
+		klog.Warningf("unable to look up lease %s: %v", req.LeaseID, err)
+		if p.pods == nil {
+			p.refused(err)
+		}
+		return nil, vaultError(err, req.LeaseID)
+	}
+
+	if lookup == nil {
+		return nil, fmt.Errorf("failure: no response returned from vault")
+	}
+
+	if renewable, _ := lookup.Data["renewable"].(bool); !renewable {
+		return nil, ErrLeaseNotRenewable
+	}
+
+	var expiry time.Time
+	if val, ok := lookup.Data["expire_time"].(string); ok {
+		expiry, _ = time.Parse(time.RFC3339Nano, val)
+	}
+
+	secret, err := vc.Sys().Renew(req.LeaseID, int(req.Increment.Seconds()))
+	if err != nil {
+		klog.Warningf("unable to renew lease %s: %v", req.LeaseID, err)
+		return nil, vaultError(err, req.LeaseID)
+	}
+
+	if secret == nil {
+		return nil, fmt.Errorf("failure: no response returned from vault")
+	}
+
+	lease := Lease{
+		ID:        secret.LeaseID,
+		Expiry:    time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second),
+		Renewable: secret.Renewable,
+		Target:    p.name,
+	}
+
+	// Once the lease reaches its maximum TTL, renewal no longer extends it
+	if !lease.Expiry.After(expiry) {
+		klog.Infof("lease %s has reached its maximum TTL", req.LeaseID)
+		return nil, ErrLeaseNotRenewable
+	}
+
+	if p.pods != nil {
+		p.pods.renewed(lease)
+	}
+
+	return &lease, nil
+}
+
+// Revoke revokes a lease. Leases issued to pods are
+// revoked with their token, as they are renewed.
+func (p *vaultProvider) Revoke(ctx context.Context, req RevokeLeaseRequest) error {
+	vc, err := p.vaultFor(ctx, IssueCredentialRequest{PodIdentity: req.PodIdentity})
+	if err != nil {
+		return err
+	}
+
+	if err := vc.Sys().Revoke(req.LeaseID); err != nil {
+		klog.Warningf("unable to revoke lease %s: %v", req.LeaseID, err)
+		if p.pods == nil {
+			p.refused(err)
+		}
+		return vaultError(err, req.LeaseID)
+	}
+
+	if p.pods != nil {
+		p.pods.released(req.LeaseID)
+	}
+
+	return nil
+}
+
+// Checks checks that the target's Vault is unsealed,
+// and that the agent holds a valid token for it.
+func (p *vaultProvider) Checks() []HealthCheck {
+	checks := []HealthCheck{p.sealCheck()}
+
+	// Pods logging in themselves may leave the agent without a token
+	if p.pods != nil && p.auth == nil && p.vault.Token() == "" {
+		return checks
+	}
+
+	token := p.tokenHealth()
+	return append(checks, HealthCheck{
+		Name:    fmt.Sprintf("token/%s", p.name),
+		OK:      token.Valid,
+		Message: token.Error,
+	})
+}
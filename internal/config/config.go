@@ -0,0 +1,260 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/mounter"
+	"github.com/spf13/viper"
+)
+
+// Version is the version of the configuration schema.
+const Version = 1
+
+// EnvPrefix prefixes the environment variables overriding the
+// configuration. (e.g., BOATHOUSE_SOCKET_PATH overrides socket.path)
+const EnvPrefix = "BOATHOUSE"
+
+// Config is the configuration of boathouse, shared by the agent and the driver.
+type Config struct {
+	// Version of the configuration schema.
+	Version int `mapstructure:"version"`
+
+	// Vault configures the connection to Vault.
+	Vault VaultConfig `mapstructure:"vault"`
+
+	// Socket configures the agent's socket.
+	Socket SocketConfig `mapstructure:"socket"`
+
+	// StateDir holds the state of mounts.
+	StateDir string `mapstructure:"stateDir"`
+
+	// Backend configures the defaults of mount backends.
+	Backend BackendConfig `mapstructure:"backend"`
+
+	// Driver configures the flexvolume driver.
+	Driver DriverConfig `mapstructure:"driver"`
+
+	// Logging configures the logs of all commands.
+	Logging LoggingConfig `mapstructure:"logging"`
+
+	// Metrics configures the agent's metrics listener.
+	Metrics MetricsConfig `mapstructure:"metrics"`
+
+	// Agent configures the issuance of credentials by the agent.
+	Agent agent.Config `mapstructure:"agent"`
+}
+
+// VaultConfig configures the connection to the default Vault target.
+type VaultConfig struct {
+	// Address of the Vault server. (VAULT_ADDR)
+	Address string `mapstructure:"address"`
+
+	// AgentAddress of a Vault agent, used instead of Address. (VAULT_AGENT_ADDR)
+	AgentAddress string `mapstructure:"agentAddress"`
+
+	// Namespace is the Vault Enterprise namespace. (VAULT_NAMESPACE)
+	Namespace string `mapstructure:"namespace"`
+
+	// TLS configures the connection to the Vault server.
+	TLS agent.TLSConfig `mapstructure:"tls"`
+}
+
+// SocketConfig configures the agent's unix socket.
+type SocketConfig struct {
+	// Path of the socket.
+	Path string `mapstructure:"path"`
+
+	// Mode of the socket file, in octal.
+	Mode string `mapstructure:"mode"`
+
+	// Group owning the socket file, by name or ID.
+	Group string `mapstructure:"group"`
+
+	// AllowedUIDs and AllowedGIDs may connect to the socket.
+	AllowedUIDs []int `mapstructure:"allowedUIDs"`
+	AllowedGIDs []int `mapstructure:"allowedGIDs"`
+
+	// ShutdownTimeout is the time allowed for in-flight requests
+	// to complete when the agent shuts down.
+	ShutdownTimeout time.Duration `mapstructure:"shutdownTimeout"`
+}
+
+// BackendConfig configures the defaults of mount backends,
+// when not set in the volume's options.
+type BackendConfig struct {
+	// Default is the backend used when a volume does not request one.
+	Default string `mapstructure:"default"`
+
+	// DirMode and FileMode are the permissions of mounted files, in octal.
+	DirMode  string `mapstructure:"dirMode"`
+	FileMode string `mapstructure:"fileMode"`
+
+	// SSHKnownHosts are the host keys trusted by sshfs, in the format of
+	// known_hosts. (e.g., an @cert-authority line for a Vault SSH host CA)
+	SSHKnownHosts string `mapstructure:"sshKnownHosts"`
+}
+
+// DriverConfig configures the flexvolume driver.
+type DriverConfig struct {
+	// MountTimeout is the time to wait for a mount to become ready.
+	MountTimeout time.Duration `mapstructure:"mountTimeout"`
+
+	// UnmountTimeout is the time to wait for the mount daemon to unmount.
+	UnmountTimeout time.Duration `mapstructure:"unmountTimeout"`
+}
+
+// LoggingConfig configures logging.
+type LoggingConfig struct {
+	// Level is the verbosity of logs.
+	Level int `mapstructure:"level"`
+
+	// File logs are written to. By default, logs are written to stderr.
+	File string `mapstructure:"file"`
+}
+
+// MetricsConfig configures the agent's metrics listener.
+type MetricsConfig struct {
+	// Address to serve metrics on. Empty to disable.
+	Address string `mapstructure:"address"`
+}
+
+// Defaults of the configuration.
+var (
+	DefaultSocketPath = filepath.Join(os.TempDir(), "boathouse.sock")
+	DefaultStateDir   = os.TempDir()
+)
+
+// SetDefaults registers the defaults of the configuration,
+// and the environment variables overriding it.
+func SetDefaults(v *viper.Viper) {
+	v.SetDefault("version", Version)
+	v.SetDefault("socket.path", DefaultSocketPath)
+	v.SetDefault("socket.mode", "0660")
+	v.SetDefault("socket.allowedUIDs", []int{0})
+	v.SetDefault("socket.allowedGIDs", []int{})
+	v.SetDefault("socket.shutdownTimeout", 30*time.Second)
+	v.SetDefault("stateDir", DefaultStateDir)
+	v.SetDefault("backend.default", mounter.DefaultBackend)
+	v.SetDefault("backend.dirMode", "0755")
+	v.SetDefault("backend.fileMode", "0644")
+	v.SetDefault("driver.mountTimeout", 30*time.Second)
+	v.SetDefault("driver.unmountTimeout", 10*time.Second)
+	v.SetDefault("logging.level", 0)
+	v.SetDefault("metrics.address", ":9090")
+
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+
+	// Unmarshal only reads the keys viper knows of, which
+	// AutomaticEnv does not add. Every key is bound, so that
+	// keys without a default may also be set from the environment.
+	bindEnv(v, "", reflect.TypeOf(Config{}))
+
+	// Vault's own environment variables
+	v.BindEnv("vault.address", "VAULT_ADDR")
+	v.BindEnv("vault.agentAddress", "VAULT_AGENT_ADDR")
+	v.BindEnv("vault.namespace", "VAULT_NAMESPACE")
+}
+
+// bindEnv binds the environment variables of the keys of t, by their
+// mapstructure tags. Lists and maps of structs cannot be set from
+// the environment, and are not bound.
+func bindEnv(v *viper.Viper, prefix string, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			bindEnv(v, key, field.Type)
+		case reflect.Slice, reflect.Map:
+			if field.Type.Elem().Kind() != reflect.Struct {
+				v.BindEnv(key)
+			}
+		default:
+			v.BindEnv(key)
+		}
+	}
+}
+
+// Load reads and validates the configuration.
+func Load(v *viper.Viper) (*Config, error) {
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("failed to read configuration: %v", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Validate checks the settings shared by all commands.
+// The agent's settings are validated when the agent is created.
+func (c *Config) Validate() error {
+	if c.Version != Version {
+		return fmt.Errorf("unsupported configuration version %d (expected %d)", c.Version, Version)
+	}
+
+	if c.Socket.Path == "" {
+		return fmt.Errorf("socket.path is required")
+	}
+
+	if _, err := ParseMode(c.Socket.Mode); err != nil {
+		return fmt.Errorf("socket.mode: %v", err)
+	}
+
+	for _, id := range append(append([]int{}, c.Socket.AllowedUIDs...), c.Socket.AllowedGIDs...) {
+		if id < 0 {
+			return fmt.Errorf("socket: invalid user or group ID %d", id)
+		}
+	}
+
+	if c.StateDir == "" {
+		return fmt.Errorf("stateDir is required")
+	}
+
+	if !mounter.Supported(c.Backend.Default) {
+		return fmt.Errorf("backend.default: unknown backend %q", c.Backend.Default)
+	}
+
+	if _, err := ParseMode(c.Backend.DirMode); err != nil {
+		return fmt.Errorf("backend.dirMode: %v", err)
+	}
+
+	if _, err := ParseMode(c.Backend.FileMode); err != nil {
+		return fmt.Errorf("backend.fileMode: %v", err)
+	}
+
+	if c.Logging.Level < 0 {
+		return fmt.Errorf("logging.level must not be negative")
+	}
+
+	return nil
+}
+
+// ParseMode parses an octal file mode.
+func ParseMode(mode string) (os.FileMode, error) {
+	val, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode %q", mode)
+	}
+
+	return os.FileMode(val), nil
+}
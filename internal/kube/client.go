@@ -0,0 +1,195 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// serviceAccountDir holds the credentials of the pod's service account.
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	tokenFile = serviceAccountDir + "/token"
+	caFile    = serviceAccountDir + "/ca.crt"
+)
+
+// dns1123Subdomain matches the names of most objects, such as secrets.
+var dns1123Subdomain = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// IsDNS1123Subdomain reports whether name is a valid object name:
+// at most 253 lowercase alphanumerics, '-' or '.', beginning
+// and ending with an alphanumeric.
+func IsDNS1123Subdomain(name string) bool {
+	return len(name) <= 253 && dns1123Subdomain.MatchString(name)
+}
+
+// Client is a minimal client of the Kubernetes API,
+// authenticated as the service account of the pod it runs in.
+type Client struct {
+	host       string
+	tokenFile  string
+	httpClient *http.Client
+}
+
+// NewInClusterClient generates a client from the environment of a pod.
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return NewClient(fmt.Sprintf("https://%s", net.JoinHostPort(host, port)), tokenFile, &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: pool,
+			},
+		},
+		Timeout: 30 * time.Second,
+	}), nil
+}
+
+// NewClient returns a client of the Kubernetes API at host, making
+// requests with httpClient, authenticated with the token in tokenFile.
+func NewClient(host, tokenFile string, httpClient *http.Client) *Client {
+	return &Client{
+		host:       host,
+		tokenFile:  tokenFile,
+		httpClient: httpClient,
+	}
+}
+
+// tokenRequest is the subset of an authentication.k8s.io/v1 TokenRequest used by the client.
+type tokenRequest struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Spec       struct {
+		Audiences         []string `json:"audiences,omitempty"`
+		ExpirationSeconds int64    `json:"expirationSeconds,omitempty"`
+	} `json:"spec"`
+	Status struct {
+		Token string `json:"token"`
+	} `json:"status"`
+}
+
+// CreateServiceAccountToken issues a token for the service account.
+func (c *Client) CreateServiceAccountToken(ctx context.Context, namespace, name string, audiences []string, expiration time.Duration) (string, error) {
+	req := tokenRequest{
+		APIVersion: "authentication.k8s.io/v1",
+		Kind:       "TokenRequest",
+	}
+	req.Spec.Audiences = audiences
+	req.Spec.ExpirationSeconds = int64(expiration.Seconds())
+
+	var resp tokenRequest
+	if err := c.do(ctx, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/serviceaccounts/%s/token", url.PathEscape(namespace), url.PathEscape(name)), req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.Status.Token, nil
+}
+
+// secret is the subset of a v1 Secret used by the client.
+type secret struct {
+	Data map[string][]byte `json:"data"`
+}
+
+// GetSecret returns the data of the secret.
+func (c *Client) GetSecret(ctx context.Context, namespace, name string) (map[string][]byte, error) {
+	if !IsDNS1123Subdomain(name) {
+		return nil, fmt.Errorf("invalid secret name %q", name)
+	}
+
+	var resp secret
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", url.PathEscape(namespace), url.PathEscape(name)), nil, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Data, nil
+}
+
+// StatusError is a failed request to the Kubernetes API.
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// do makes a JSON request to the Kubernetes API.
+func (c *Client) do(ctx context.Context, method, path string, req interface{}, resp interface{}) error {
+	var body bytes.Buffer
+	if req != nil {
+		if err := json.NewEncoder(&body).Encode(req); err != nil {
+			return err
+		}
+	}
+
+	hreq, err := http.NewRequest(method, c.host+path, &body)
+	if err != nil {
+		return err
+	}
+	hreq = hreq.WithContext(ctx)
+
+	// The token is rotated by the kubelet, so it is read for each request
+	token, err := ioutil.ReadFile(c.tokenFile)
+	if err != nil {
+		return err
+	}
+
+	hreq.Header.Set("Authorization", fmt.Sprintf("Bearer %s", strings.TrimSpace(string(token))))
+	hreq.Header.Set("Content-Type", "application/json")
+	hreq.Header.Set("Accept", "application/json")
+
+	hresp, err := c.httpClient.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+
+	b, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
+		return err
+	}
+
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		serr := &StatusError{
+			Code:    hresp.StatusCode,
+			Message: fmt.Sprintf("%s %s: unexpected status code: %d", method, path, hresp.StatusCode),
+		}
+
+		// Kubernetes returns a Status object describing the failure
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &status) == nil && status.Message != "" {
+			serr.Message = fmt.Sprintf("%s %s: %s", method, path, status.Message)
+		}
+		return serr
+	}
+
+	return json.Unmarshal(b, resp)
+}
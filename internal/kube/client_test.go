@@ -0,0 +1,78 @@
+package kube
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsDNS1123Subdomain(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "minio", valid: true},
+		{name: "minio.team-a", valid: true},
+		{name: "0", valid: true},
+		{name: ""},
+		{name: "Minio"},
+		{name: "-minio"},
+		{name: "minio-"},
+		{name: "minio..team-a"},
+		{name: "../configmaps/minio"},
+		{name: "minio?watch=true"},
+		{name: "minio%2f"},
+		{name: strings.Repeat("a", 254)},
+	}
+
+	for _, tt := range tests {
+		if got := IsDNS1123Subdomain(tt.name); got != tt.valid {
+			t.Errorf("IsDNS1123Subdomain(%q) = %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+func TestGetSecret(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		w.Write([]byte(`{"data": {"accessKeyId": "YWNjZXNz"}}`))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "kube")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(srv.URL, tokenFile, srv.Client())
+
+	data, err := c.GetSecret(context.Background(), "team-a", "minio")
+	if err != nil || string(data["accessKeyId"]) != "access" {
+		t.Errorf("got %v, %v, want the secret", data, err)
+	}
+
+	// Names which are not object names never reach the API
+	if _, err := c.GetSecret(context.Background(), "team-a", "../configmaps/minio"); err == nil {
+		t.Errorf("got no error for an invalid secret name")
+	}
+
+	// Namespaces are escaped, so that they remain one segment of the path
+	c.GetSecret(context.Background(), "team-a/secrets/other", "minio")
+
+	want := []string{"/api/v1/namespaces/team-a/secrets/minio", "/api/v1/namespaces/team-a%2Fsecrets%2Fother/secrets/minio"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("got requests %v, want %v", paths, want)
+	}
+}
@@ -0,0 +1,159 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/utils"
+	"gopkg.in/yaml.v2"
+)
+
+// blobfuse mounts Azure Storage containers using blobfuse2.
+type blobfuse struct {
+	config Config
+}
+
+func newBlobfuse(config Config) Mounter {
+	return &blobfuse{
+		config: config,
+	}
+}
+
+func (b *blobfuse) Validate() error {
+	if b.container() == "" {
+		return fmt.Errorf("container option is required")
+	}
+
+	if _, err := b.config.umask(); err != nil {
+		return err
+	}
+
+	return b.config.numericOptions("uid", "gid")
+}
+
+// WriteCredentials renders the blobfuse2 configuration.
+// blobfuse2 watches its configuration, so rotated account keys
+// and SAS tokens are picked up without remounting.
+func (b *blobfuse) WriteCredentials(creds *agent.IssueCredentialResponse) error {
+	accountName := creds.AccountName
+	if accountName == "" {
+		accountName = b.config.option("accountName", "")
+	}
+	if accountName == "" {
+		return fmt.Errorf("no storage account name was issued or provided in the accountName option")
+	}
+
+	azstorage := map[string]string{
+		"type":         "block",
+		"account-name": accountName,
+		"container":    b.container(),
+	}
+
+	if val, ok := b.config.Options["endpoint"]; ok {
+		azstorage["endpoint"] = val
+	}
+
+	switch {
+	case creds.SASToken != "":
+		azstorage["mode"] = "sas"
+		azstorage["sas"] = creds.SASToken
+	case creds.AccountKey != "":
+		azstorage["mode"] = "key"
+		azstorage["account-key"] = creds.AccountKey
+	case creds.ClientID != "" && creds.ClientSecret != "":
+		tenantID, ok := b.config.Options["tenantId"]
+		if !ok {
+			return fmt.Errorf("tenantId option is required for service principal credentials")
+		}
+
+		azstorage["mode"] = "spn"
+		azstorage["clientid"] = creds.ClientID
+		azstorage["clientsecret"] = creds.ClientSecret
+		azstorage["tenantid"] = tenantID
+	default:
+		return fmt.Errorf("no account key, SAS token or service principal was issued")
+	}
+
+	cfg := map[string]interface{}{
+		"allow-other": true,
+		"components":  []string{"libfuse", "file_cache", "attr_cache", "azstorage"},
+		"file_cache": map[string]string{
+			"path": b.cacheDir(),
+		},
+		"azstorage": azstorage,
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(b.configFile(), data, 0600)
+}
+
+func (b *blobfuse) Command(ctx context.Context) (*exec.Cmd, error) {
+	umask, err := b.config.umask()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(b.cacheDir(), 0700); err != nil {
+		return nil, err
+	}
+
+	blobfuseArgs := []string{
+		"mount",
+		b.config.Target,
+		"--config-file", b.configFile(),
+
+		// Run in foreground mode
+		"--foreground",
+		"-o", fmt.Sprintf("umask=%04o", umask),
+	}
+
+	// UID
+	if val, ok := b.config.Options["uid"]; ok {
+		blobfuseArgs = append(blobfuseArgs, "-o", fmt.Sprintf("uid=%s", val))
+	}
+
+	// GID
+	if val, ok := b.config.Options["gid"]; ok {
+		blobfuseArgs = append(blobfuseArgs, "-o", fmt.Sprintf("gid=%s", val))
+	}
+
+	return exec.CommandContext(ctx, "blobfuse2", blobfuseArgs...), nil
+}
+
+func (b *blobfuse) Ready() (bool, error) {
+	return utils.IsMountPoint(b.config.Target)
+}
+
+func (b *blobfuse) Unmount(proc *os.Process) error {
+	// blobfuse2 unmounts the container when it is terminated
+	return terminate(proc, b.config.Target)
+}
+
+func (b *blobfuse) Cleanup() error {
+	if err := os.RemoveAll(b.cacheDir()); err != nil {
+		return err
+	}
+
+	return removeFiles(b.configFile())
+}
+
+// container returns the container to mount. The bucket
+// option is accepted for consistency with other backends.
+func (b *blobfuse) container() string {
+	return b.config.option("container", b.config.option("bucket", ""))
+}
+
+func (b *blobfuse) configFile() string {
+	return fmt.Sprintf("%s.blobfuse.yaml", b.config.StatePrefix)
+}
+
+func (b *blobfuse) cacheDir() string {
+	return fmt.Sprintf("%s.blobfuse", b.config.StatePrefix)
+}
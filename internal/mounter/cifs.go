@@ -0,0 +1,138 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/utils"
+	"k8s.io/klog"
+)
+
+// cifs mounts SMB/CIFS shares using the kernel client.
+type cifs struct {
+	config Config
+}
+
+func newCIFS(config Config) Mounter {
+	return &cifs{
+		config: config,
+	}
+}
+
+func (c *cifs) Validate() error {
+	share, ok := c.config.Options["share"]
+	if !ok {
+		return fmt.Errorf("share option is required")
+	}
+
+	if !strings.HasPrefix(share, "//") {
+		return fmt.Errorf("share must be of the form //server/share: %q", share)
+	}
+
+	if err := c.config.numericOptions("uid", "gid"); err != nil {
+		return err
+	}
+
+	// The modes are passed as mount options, so must be octal
+	if _, err := c.config.umask(); err != nil {
+		return err
+	}
+
+	if err := c.config.plainOptions(",", "vers"); err != nil {
+		return err
+	}
+
+	if err := c.config.plainOptions("\n", "domain"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WriteCredentials writes a mount.cifs credentials file,
+// which is only readable by root.
+func (c *cifs) WriteCredentials(creds *agent.IssueCredentialResponse) error {
+	if creds.Username == "" || creds.Password == "" {
+		return fmt.Errorf("no username and password were issued")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "username=%s\n", creds.Username)
+	fmt.Fprintf(&b, "password=%s\n", creds.Password)
+	if val, ok := c.config.Options["domain"]; ok {
+		fmt.Fprintf(&b, "domain=%s\n", val)
+	}
+
+	return writeFile(c.config.credentialsFile(), []byte(b.String()), 0600)
+}
+
+// Command mounts the share. Unlike FUSE backends,
+// the command exits once the share is mounted.
+func (c *cifs) Command(ctx context.Context) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, "mount", c.mountArgs()...), nil
+}
+
+func (c *cifs) Ready() (bool, error) {
+	return utils.IsMountPoint(c.config.Target)
+}
+
+// Reload remounts the share in place (mount -o remount), with the rotated
+// password. The mount is not replaced, as pods hold their own copy of it.
+// The kernel uses the new password when the session reconnects. Linux 6.3
+// and later accept a new password on remount; earlier kernels ignore it,
+// and keep the password the share was mounted with.
+func (c *cifs) Reload(proc *os.Process) error {
+	out, err := exec.Command("mount", c.mountArgs("remount")...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remount with rotated credentials: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	klog.Infof("remounted %s with rotated credentials", c.config.Target)
+	return nil
+}
+
+func (c *cifs) Unmount(proc *os.Process) error {
+	return utils.Unmount(c.config.Target)
+}
+
+func (c *cifs) Cleanup() error {
+	return removeFiles(c.config.credentialsFile())
+}
+
+// mountArgs builds the arguments to mount, with the flags (e.g., remount)
+// before the mount options. The mount options are last.
+func (c *cifs) mountArgs(flags ...string) []string {
+	options := c.config.Options
+
+	mountOptions := append(flags,
+		fmt.Sprintf("credentials=%s", c.config.credentialsFile()),
+		fmt.Sprintf("dir_mode=%s", c.config.option("dirMode", defaultDirMode)),
+		fmt.Sprintf("file_mode=%s", c.config.option("fileMode", defaultFileMode)),
+	)
+
+	// UID
+	if val, ok := options["uid"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("uid=%s", val))
+	}
+
+	// GID
+	if val, ok := options["gid"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("gid=%s", val))
+	}
+
+	// SMB protocol version
+	if val, ok := options["vers"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("vers=%s", val))
+	}
+
+	return []string{
+		"-t", "cifs",
+		options["share"],
+		c.config.Target,
+		"-o", strings.Join(mountOptions, ","),
+	}
+}
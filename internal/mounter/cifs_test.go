@@ -0,0 +1,63 @@
+package mounter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCIFSValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		wantErr bool
+	}{
+		{name: "valid", options: map[string]string{"share": "//server/share", "uid": "1000", "gid": "100", "vers": "3.0", "dirMode": "0750", "fileMode": "0640"}},
+		{name: "missing share", options: map[string]string{}, wantErr: true},
+		{name: "share without server", options: map[string]string{"share": "server/share"}, wantErr: true},
+		{name: "option in uid", options: map[string]string{"share": "//server/share", "uid": "0,sec=none"}, wantErr: true},
+		{name: "option in gid", options: map[string]string{"share": "//server/share", "gid": "0,sec=none"}, wantErr: true},
+		{name: "option in vers", options: map[string]string{"share": "//server/share", "vers": "3.0,sec=none"}, wantErr: true},
+		{name: "option in dirMode", options: map[string]string{"share": "//server/share", "dirMode": "0755,credentials=/etc/other.creds"}, wantErr: true},
+		{name: "option in fileMode", options: map[string]string{"share": "//server/share", "fileMode": "0644,sec=none"}, wantErr: true},
+		{name: "line in domain", options: map[string]string{"share": "//server/share", "domain": "CORP\npassword=other"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCIFS(Config{Target: "/mnt/share", Options: tt.options, StatePrefix: "/tmp/share"})
+
+			err := c.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCIFSMountArgs(t *testing.T) {
+	c := &cifs{config: Config{
+		Target:      "/mnt/share",
+		Options:     map[string]string{"share": "//server/share", "uid": "1000", "vers": "3.0"},
+		StatePrefix: "/tmp/share",
+	}}
+
+	tests := []struct {
+		name  string
+		flags []string
+		want  string
+	}{
+		{name: "mount", want: "credentials=/tmp/share.creds,dir_mode=0755,file_mode=0644,uid=1000,vers=3.0"},
+		{name: "remount", flags: []string{"remount"}, want: "remount,credentials=/tmp/share.creds,dir_mode=0755,file_mode=0644,uid=1000,vers=3.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := c.mountArgs(tt.flags...)
+			want := []string{"-t", "cifs", "//server/share", "/mnt/share", "-o", tt.want}
+
+			if strings.Join(args, " ") != strings.Join(want, " ") {
+				t.Errorf("got %q, want %q", args, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/utils"
+	"gopkg.in/ini.v1"
+	"k8s.io/klog"
+)
+
+// goofys mounts S3 buckets using goofys.
+type goofys struct {
+	config Config
+}
+
+func newGoofys(config Config) Mounter {
+	return &goofys{
+		config: config,
+	}
+}
+
+func (g *goofys) Validate() error {
+	if _, ok := g.config.Options["bucket"]; !ok {
+		return fmt.Errorf("bucket option is required")
+	}
+
+	return nil
+}
+
+func (g *goofys) WriteCredentials(creds *agent.IssueCredentialResponse) error {
+	return writeAWSCredentials(g.config.credentialsFile(), creds)
+}
+
+func (g *goofys) Command(ctx context.Context) (*exec.Cmd, error) {
+	options := g.config.Options
+	goofysArgs := []string{}
+
+	// Run in foreground mode
+	goofysArgs = append(goofysArgs, "-f")
+
+	// File/Directory modes
+	goofysArgs = append(goofysArgs,
+		"-o", "allow_other",
+		"--dir-mode", g.config.option("dirMode", defaultDirMode),
+		"--file-mode", g.config.option("fileMode", defaultFileMode),
+	)
+
+	// Endpoint
+	if val, ok := options["endpoint"]; ok {
+		goofysArgs = append(goofysArgs, "--endpoint", val)
+	}
+
+	// Region
+	if val, ok := options["region"]; ok {
+		goofysArgs = append(goofysArgs, "--region", val)
+	}
+
+	// UID
+	if val, ok := options["uid"]; ok {
+		goofysArgs = append(goofysArgs, "--uid", val)
+	}
+
+	// GID
+	if val, ok := options["gid"]; ok {
+		goofysArgs = append(goofysArgs, "--gid", val)
+	}
+
+	// Debug
+	if val, ok := options["debug_s3"]; ok {
+		bval, err := strconv.ParseBool(val)
+		if err != nil {
+			klog.Warningf("failed to parse bool for debug_s3: %s : %v", val, err)
+		}
+		if bval {
+			goofysArgs = append(goofysArgs, "--debug_s3")
+		}
+	}
+
+	// Credentials
+	goofysArgs = append(goofysArgs, "--cred-filename", g.config.credentialsFile())
+
+	// Bucket (positional argument)
+	goofysArgs = append(goofysArgs, options["bucket"])
+
+	// Mount path (positional argument)
+	goofysArgs = append(goofysArgs, g.config.Target)
+
+	return exec.CommandContext(ctx, "goofys", goofysArgs...), nil
+}
+
+func (g *goofys) Ready() (bool, error) {
+	return utils.IsMountPoint(g.config.Target)
+}
+
+func (g *goofys) Unmount(proc *os.Process) error {
+	// goofys unmounts the bucket when it is terminated
+	return terminate(proc, g.config.Target)
+}
+
+func (g *goofys) Cleanup() error {
+	return removeFiles(g.config.credentialsFile())
+}
+
+// writeAWSCredentials writes credentials to filename in the
+// AWS shared credentials file format.
+func writeAWSCredentials(filename string, creds *agent.IssueCredentialResponse) error {
+	if err := checkAccessKeys(creds); err != nil {
+		return err
+	}
+
+	ini.PrettyFormat = false
+	cfg := ini.Empty()
+	cfgsec, err := cfg.NewSection("default")
+	if err != nil {
+		return err
+	}
+	if _, err = cfgsec.NewKey("aws_access_key_id", creds.AccessKey); err != nil {
+		return err
+	}
+	if _, err = cfgsec.NewKey("aws_secret_access_key", creds.SecretKey); err != nil {
+		return err
+	}
+	if creds.SessionToken != "" {
+		if _, err = cfgsec.NewKey("aws_session_token", creds.SessionToken); err != nil {
+			return err
+		}
+	}
+	if _, err = cfgsec.NewKey("expires_at", creds.Lease.Expiry.Format(time.RFC3339)); err != nil {
+		return err
+	}
+
+	return cfg.SaveTo(filename)
+}
@@ -0,0 +1,242 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/utils"
+	"k8s.io/klog"
+)
+
+// DefaultBackend is the backend used when no backend is requested.
+const DefaultBackend = "goofys"
+
+const (
+	defaultDirMode  = "0755"
+	defaultFileMode = "0644"
+)
+
+// Mounter is a storage backend responsible for mounting a volume.
+type Mounter interface {
+	// Validate checks that the options are valid for the backend.
+	Validate() error
+
+	// WriteCredentials renders the issued credentials
+	// in the format expected by the backend.
+	WriteCredentials(creds *agent.IssueCredentialResponse) error
+
+	// Command builds the command which performs the mount.
+	Command(ctx context.Context) (*exec.Cmd, error)
+
+	// Ready reports whether the mount is ready to be used.
+	Ready() (bool, error)
+
+	// Unmount unmounts the volume. proc is the process started
+	// from Command, or nil if the process is not known.
+	Unmount(proc *os.Process) error
+
+	// Cleanup removes any files written by the backend.
+	Cleanup() error
+}
+
+// Reloader is implemented by backends which must be notified
+// after their credentials have been rewritten.
+type Reloader interface {
+	// Reload applies the rewritten credentials to the running mount.
+	// proc is the process started from Command.
+	Reload(proc *os.Process) error
+}
+
+// Requester is implemented by backends which add
+// to the request for credentials. (e.g., a public key to sign)
+type Requester interface {
+	// PrepareRequest updates the request before it is sent to the agent.
+	PrepareRequest(req *agent.IssueCredentialRequest) error
+}
+
+// Config is the configuration shared by all backends.
+type Config struct {
+	// Target is the directory where the volume is mounted.
+	Target string
+
+	// Options are the options passed by the kubelet.
+	Options map[string]string
+
+	// StatePrefix is the path prefix for files belonging to the mount.
+	StatePrefix string
+}
+
+// Factory creates a backend from the configuration.
+type Factory func(config Config) Mounter
+
+var backends = map[string]Factory{
+	"blobfuse": newBlobfuse,
+	"cifs":     newCIFS,
+	"goofys":   newGoofys,
+	"rclone":   newRclone,
+	"s3fs":     newS3fs,
+	"sshfs":    newSSHFS,
+}
+
+// Backend returns the name of the backend requested in the options.
+func Backend(options map[string]string) string {
+	if val, ok := options["driver"]; ok {
+		return val
+	}
+
+	if val, ok := options["backend"]; ok {
+		return val
+	}
+
+	return DefaultBackend
+}
+
+// Supported reports whether the named backend exists.
+func Supported(name string) bool {
+	_, ok := backends[name]
+	return ok
+}
+
+// New creates the named backend.
+func New(name string, config Config) (Mounter, error) {
+	factory, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", name)
+	}
+
+	return factory(config), nil
+}
+
+// credentialsFile returns the path of the credentials file for the mount.
+func (c Config) credentialsFile() string {
+	return fmt.Sprintf("%s.creds", c.StatePrefix)
+}
+
+// option returns the value of the option, or def if it is not set.
+func (c Config) option(key, def string) string {
+	if val, ok := c.Options[key]; ok {
+		return val
+	}
+
+	return def
+}
+
+// numericOptions checks that each of the options which is set is a number.
+func (c Config) numericOptions(keys ...string) error {
+	for _, key := range keys {
+		if val, ok := c.Options[key]; ok {
+			if _, err := strconv.ParseUint(val, 10, 32); err != nil {
+				return fmt.Errorf("%s option must be a number: %q", key, val)
+			}
+		}
+	}
+
+	return nil
+}
+
+// plainOptions checks that none of the options which are set contain
+// any of chars. Options joined into a single option string (e.g., "-o")
+// could otherwise add options of their own, which run as root.
+func (c Config) plainOptions(chars string, keys ...string) error {
+	for _, key := range keys {
+		if val, ok := c.Options[key]; ok && strings.ContainsAny(val, chars) {
+			return fmt.Errorf("%s option may not contain any of %q: %q", key, chars, val)
+		}
+	}
+
+	return nil
+}
+
+// positionalOptions checks that none of the options which are set
+// would be read as a flag when passed as a positional argument.
+func (c Config) positionalOptions(keys ...string) error {
+	for _, key := range keys {
+		if val, ok := c.Options[key]; ok && strings.HasPrefix(val, "-") {
+			return fmt.Errorf("%s option may not begin with \"-\": %q", key, val)
+		}
+	}
+
+	return nil
+}
+
+// umask derives a single umask from the directory and file modes,
+// for backends which do not accept separate modes.
+func (c Config) umask() (uint64, error) {
+	dirMode, err := strconv.ParseUint(c.option("dirMode", defaultDirMode), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse dirMode: %v", err)
+	}
+
+	fileMode, err := strconv.ParseUint(c.option("fileMode", defaultFileMode), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse fileMode: %v", err)
+	}
+
+	return 0777 &^ (dirMode | fileMode), nil
+}
+
+// checkAccessKeys checks that an access key and secret key were issued.
+// They are empty when the secret's data has none of the keys of the fields.
+func checkAccessKeys(creds *agent.IssueCredentialResponse) error {
+	if creds.AccessKey == "" || creds.SecretKey == "" {
+		return fmt.Errorf("no access key and secret key were issued: the secret may need a field mapping (agent.fields)")
+	}
+
+	return nil
+}
+
+// terminate asks a FUSE process to unmount and exit.
+// Without a process, the target is unmounted directly.
+func terminate(proc *os.Process, target string) error {
+	if proc == nil {
+		return utils.Unmount(target)
+	}
+
+	return proc.Signal(syscall.SIGTERM)
+}
+
+// writeFile atomically replaces filename with data, so that
+// backends never observe a partially written file.
+func writeFile(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), fmt.Sprintf(".%s.", filepath.Base(filename)))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// removeFiles removes each of the files, ignoring those which do not exist.
+func removeFiles(filenames ...string) error {
+	for _, filename := range filenames {
+		klog.Infof("removing file %q", filename)
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,418 @@
+package mounter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/utils"
+)
+
+// rcloneRemote is the name of the remote in the generated configuration.
+const rcloneRemote = "boathouse"
+
+// rcloneProcessCredentialsTTL is how long rclone uses the keys of an s3
+// remote before running its credential process again, to read rotated keys.
+const rcloneProcessCredentialsTTL = 15 * time.Second
+
+// rcloneReloadWait is the time for rclone to read rotated keys.
+var rcloneReloadWait = rcloneProcessCredentialsTTL
+
+// rcloneProcessPathChars are the characters allowed in the
+// command of the credential process, which is run by a shell.
+const rcloneProcessPathChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789/._-"
+
+// rcloneOptionPrefix prefixes options which are copied
+// into the generated remote configuration. (e.g., rclone.provider)
+const rcloneOptionPrefix = "rclone."
+
+// rcloneOptions are the options of each remote type which may be set
+// with rcloneOptionPrefix. Options which read files or credentials of
+// the node, or run commands (e.g., env_auth, key_file, ssh), are excluded.
+var rcloneOptions = map[string][]string{
+	"s3": {
+		"provider", "region", "endpoint", "location_constraint", "acl",
+		"storage_class", "force_path_style", "v2_auth", "upload_cutoff",
+		"chunk_size", "list_chunk", "no_check_bucket",
+	},
+	"azureblob": {
+		"endpoint", "access_tier", "upload_cutoff", "chunk_size", "list_chunk",
+	},
+	"gcs": {
+		"project_number", "location", "storage_class", "object_acl",
+		"bucket_acl", "bucket_policy_only",
+	},
+	"sftp": {
+		"host", "port", "disable_hashcheck", "use_insecure_cipher",
+		"shell_type", "set_modtime", "idle_timeout", "chunk_size", "concurrency",
+	},
+	"webdav": {
+		"url", "vendor", "headers",
+	},
+}
+
+// rcloneCredentials maps each supported remote type to a function
+// which renders the issued credentials as remote configuration.
+var rcloneCredentials = map[string]func(config Config, creds *agent.IssueCredentialResponse) (map[string]string, error){
+	"s3": func(config Config, creds *agent.IssueCredentialResponse) (map[string]string, error) {
+		if err := checkAccessKeys(creds); err != nil {
+			return nil, err
+		}
+
+		// The keys are read from a credential process by the AWS SDK,
+		// so that rotated keys are used without remounting.
+		return map[string]string{
+			"env_auth": "true",
+		}, nil
+	},
+	"azureblob": rcloneAzureBlob,
+	"gcs": func(config Config, creds *agent.IssueCredentialResponse) (map[string]string, error) {
+		// The service account key is JSON, which must fit on a single line.
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, []byte(creds.SecretKey)); err != nil {
+			return nil, fmt.Errorf("failed to parse service account credentials: %v", err)
+		}
+
+		return map[string]string{
+			"service_account_credentials": buf.String(),
+		}, nil
+	},
+	"sftp":   rclonePassword,
+	"webdav": rclonePassword,
+}
+
+// rcloneVFSCacheModes are the supported values of rclone's --vfs-cache-mode.
+var rcloneVFSCacheModes = map[string]bool{
+	"off":     true,
+	"minimal": true,
+	"writes":  true,
+	"full":    true,
+}
+
+// rclone mounts remotes using rclone.
+type rclone struct {
+	config Config
+}
+
+func newRclone(config Config) Mounter {
+	return &rclone{
+		config: config,
+	}
+}
+
+func (r *rclone) Validate() error {
+	remoteType := r.remoteType()
+	if _, ok := rcloneCredentials[remoteType]; !ok {
+		return fmt.Errorf("unsupported remoteType %q", remoteType)
+	}
+
+	if mode := r.config.option("vfsCacheMode", "off"); !rcloneVFSCacheModes[mode] {
+		return fmt.Errorf("unsupported vfsCacheMode %q", mode)
+	}
+
+	for key := range r.config.Options {
+		if strings.HasPrefix(key, rcloneOptionPrefix) && !rcloneOptionAllowed(remoteType, strings.TrimPrefix(key, rcloneOptionPrefix)) {
+			return fmt.Errorf("option %s is not supported for remoteType %q", key, remoteType)
+		}
+	}
+
+	return r.config.numericOptions("uid", "gid")
+}
+
+// WriteCredentials renders the remote configuration, including the credentials.
+func (r *rclone) WriteCredentials(creds *agent.IssueCredentialResponse) error {
+	params, err := r.parameters(creds)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{}
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[%s]\n", rcloneRemote)
+	for _, key := range keys {
+		if strings.ContainsAny(params[key], "\r\n") {
+			return fmt.Errorf("value of %s must not contain line breaks", key)
+		}
+		fmt.Fprintf(&buf, "%s = %s\n", key, params[key])
+	}
+
+	if r.remoteType() == "s3" {
+		if err := r.writeProcessCredentials(creds); err != nil {
+			return err
+		}
+	}
+
+	return writeFile(r.configFile(), buf.Bytes(), 0600)
+}
+
+func (r *rclone) Command(ctx context.Context) (*exec.Cmd, error) {
+	rcloneArgs := []string{
+		"mount",
+		fmt.Sprintf("%s:%s", rcloneRemote, r.remotePath()),
+		r.config.Target,
+		"--config", r.configFile(),
+		"--allow-other",
+		"--dir-perms", r.config.option("dirMode", defaultDirMode),
+		"--file-perms", r.config.option("fileMode", defaultFileMode),
+		"--vfs-cache-mode", r.config.option("vfsCacheMode", "off"),
+	}
+
+	// UID
+	if val, ok := r.config.Options["uid"]; ok {
+		rcloneArgs = append(rcloneArgs, "--uid", val)
+	}
+
+	// GID
+	if val, ok := r.config.Options["gid"]; ok {
+		rcloneArgs = append(rcloneArgs, "--gid", val)
+	}
+
+	cmd := exec.CommandContext(ctx, "rclone", rcloneArgs...)
+	if r.remoteType() == "s3" {
+		cmd.Env = r.awsEnv()
+	}
+
+	return cmd, nil
+}
+
+func (r *rclone) Ready() (bool, error) {
+	return utils.IsMountPoint(r.config.Target)
+}
+
+// Reload applies rotated credentials to the running mount. rclone re-reads
+// the keys of s3 remotes from their credential process once they expire,
+// which Reload waits for, so that the previous keys may be revoked.
+// It reads the credentials of other remotes once, when mounted, so their
+// rotation is refused: the mount is not replaced, as pods hold their own
+// copy of it.
+func (r *rclone) Reload(proc *os.Process) error {
+	if r.remoteType() == "s3" {
+		time.Sleep(rcloneReloadWait)
+		return nil
+	}
+
+	return fmt.Errorf("rclone cannot use rotated credentials for a mounted %s remote: the pod must be restarted to use them", r.remoteType())
+}
+
+func (r *rclone) Unmount(proc *os.Process) error {
+	// rclone unmounts the remote when it is terminated
+	return terminate(proc, r.config.Target)
+}
+
+func (r *rclone) Cleanup() error {
+	return removeFiles(r.configFile(), r.config.credentialsFile(), r.awsConfigFile())
+}
+
+// parameters builds the remote configuration.
+func (r *rclone) parameters(creds *agent.IssueCredentialResponse) (map[string]string, error) {
+	params := map[string]string{}
+
+	switch r.remoteType() {
+	case "s3":
+		params["provider"] = "Other"
+		if val, ok := r.config.Options["endpoint"]; ok {
+			params["endpoint"] = val
+		}
+		if val, ok := r.config.Options["region"]; ok {
+			params["region"] = val
+		}
+	case "webdav":
+		if val, ok := r.config.Options["endpoint"]; ok {
+			params["url"] = val
+		}
+	}
+
+	for key, val := range r.config.Options {
+		name := strings.TrimPrefix(key, rcloneOptionPrefix)
+		if strings.HasPrefix(key, rcloneOptionPrefix) && rcloneOptionAllowed(r.remoteType(), name) {
+			params[name] = val
+		}
+	}
+
+	credParams, err := rcloneCredentials[r.remoteType()](r.config, creds)
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range credParams {
+		params[key] = val
+	}
+
+	// The type is set last, so that it cannot be overridden
+	params["type"] = r.remoteType()
+	return params, nil
+}
+
+// rcloneOptionAllowed reports whether the option may be set for the remote type.
+func rcloneOptionAllowed(remoteType, name string) bool {
+	for _, allowed := range rcloneOptions[remoteType] {
+		if name == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *rclone) remoteType() string {
+	return r.config.option("remoteType", "s3")
+}
+
+// remotePath is the path within the remote to mount.
+// For bucket based remotes, this starts with the bucket.
+func (r *rclone) remotePath() string {
+	return strings.TrimPrefix(path.Join(r.config.option("bucket", ""), r.config.option("path", "")), "/")
+}
+
+func (r *rclone) configFile() string {
+	return fmt.Sprintf("%s.rclone.conf", r.config.StatePrefix)
+}
+
+func (r *rclone) awsConfigFile() string {
+	return fmt.Sprintf("%s.aws.conf", r.config.StatePrefix)
+}
+
+// processCredentials are keys in the output format of an AWS credential process.
+type processCredentials struct {
+	Version         int    `json:"Version"`
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+// writeProcessCredentials writes the keys, and an AWS configuration
+// whose credential process reads them. (see WriteProcessCredentials)
+func (r *rclone) writeProcessCredentials(creds *agent.IssueCredentialResponse) error {
+	b, err := json.Marshal(processCredentials{
+		Version:         1,
+		AccessKeyID:     creds.AccessKey,
+		SecretAccessKey: creds.SecretKey,
+		SessionToken:    creds.SessionToken,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(r.config.credentialsFile(), b, 0600); err != nil {
+		return err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the credential process: %v", err)
+	}
+
+	// The SDK runs the credential process with a shell, without quoting
+	for _, arg := range []string{exe, r.config.credentialsFile()} {
+		if strings.TrimLeft(arg, rcloneProcessPathChars) != "" {
+			return fmt.Errorf("path of the credential process may only contain letters, digits and any of %q: %q", "/._-", arg)
+		}
+	}
+
+	config := fmt.Sprintf("[default]\ncredential_process = %s credential-process %s\n", exe, r.config.credentialsFile())
+	return writeFile(r.awsConfigFile(), []byte(config), 0600)
+}
+
+// awsEnv is the environment of rclone for s3 remotes, in which the AWS SDK
+// only reads the keys from the credential process, and not those of the node.
+func (r *rclone) awsEnv() []string {
+	env := []string{}
+	for _, val := range os.Environ() {
+		if !strings.HasPrefix(val, "AWS_") {
+			env = append(env, val)
+		}
+	}
+
+	return append(env,
+		"AWS_CONFIG_FILE="+r.awsConfigFile(),
+		"AWS_SHARED_CREDENTIALS_FILE="+os.DevNull,
+		"AWS_EC2_METADATA_DISABLED=true",
+	)
+}
+
+// WriteProcessCredentials writes the keys in file as the output of an AWS
+// credential process, expiring after rcloneProcessCredentialsTTL so that
+// rotated keys are read again.
+func WriteProcessCredentials(w io.Writer, file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var creds processCredentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+
+	creds.Expiration = time.Now().Add(rcloneProcessCredentialsTTL).UTC().Format(time.RFC3339)
+	return json.NewEncoder(w).Encode(creds)
+}
+
+// rclonePassword renders credentials for remotes authenticating
+// with a username and password. rclone requires the password
+// to be obscured, which is done by rclone itself.
+func rclonePassword(config Config, creds *agent.IssueCredentialResponse) (map[string]string, error) {
+	obscure := exec.Command("rclone", "obscure", "-")
+	obscure.Stdin = strings.NewReader(creds.SecretKey)
+	pass, err := obscure.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to obscure password: %v", err)
+	}
+
+	return map[string]string{
+		"user": creds.AccessKey,
+		"pass": strings.TrimSpace(string(pass)),
+	}, nil
+}
+
+// rcloneAzureBlob renders Azure storage account credentials: an account key,
+// or a SAS token. A SAS token is given to rclone as the URL of the container.
+// As for blobfuse, the account may be provided in the accountName option.
+func rcloneAzureBlob(config Config, creds *agent.IssueCredentialResponse) (map[string]string, error) {
+	account := creds.AccountName
+	if account == "" {
+		account = config.option("accountName", "")
+	}
+	if account == "" {
+		return nil, fmt.Errorf("no storage account name was issued or provided in the accountName option")
+	}
+
+	switch {
+	case creds.SASToken != "":
+		endpoint := config.option("rclone.endpoint", fmt.Sprintf("https://%s.blob.core.windows.net", account))
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse endpoint: %v", err)
+		}
+
+		u.Path = path.Join("/", config.option("bucket", ""))
+		u.RawQuery = strings.TrimPrefix(creds.SASToken, "?")
+
+		return map[string]string{
+			"sas_url": u.String(),
+		}, nil
+	case creds.AccountKey != "":
+		return map[string]string{
+			"account": account,
+			"key":     creds.AccountKey,
+		}, nil
+	default:
+		return nil, fmt.Errorf("no account key or SAS token was issued")
+	}
+}
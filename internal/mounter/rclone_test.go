@@ -0,0 +1,129 @@
+package mounter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StatCan/boathouse/internal/agent"
+)
+
+func TestRcloneValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		wantErr bool
+	}{
+		{name: "valid", options: map[string]string{"bucket": "data", "rclone.region": "ca-central-1", "uid": "1000"}},
+		{name: "unsupported remote type", options: map[string]string{"remoteType": "local"}, wantErr: true},
+		{name: "option of another remote type", options: map[string]string{"rclone.url": "https://example.com"}, wantErr: true},
+		{name: "type", options: map[string]string{"rclone.type": "local"}, wantErr: true},
+		{name: "node credentials", options: map[string]string{"rclone.env_auth": "true"}, wantErr: true},
+		{name: "command", options: map[string]string{"remoteType": "sftp", "rclone.ssh": "sh -c id"}, wantErr: true},
+		{name: "option in uid", options: map[string]string{"uid": "0 --allow-root"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRclone(Config{Target: "/mnt/data", Options: tt.options, StatePrefix: "/tmp/data"})
+
+			err := r.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRcloneWriteCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &rclone{config: Config{
+		Target:      "/mnt/data",
+		Options:     map[string]string{"bucket": "data", "endpoint": "https://minio.example.com", "rclone.region": "ca-central-1"},
+		StatePrefix: filepath.Join(dir, "data"),
+	}}
+
+	if err := r.WriteCredentials(&agent.IssueCredentialResponse{AccessKey: "access", SecretKey: "secret"}); err != nil {
+		t.Fatalf("WriteCredentials: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(r.configFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The keys are only read from the credential process
+	want := "[boathouse]\nendpoint = https://minio.example.com\nenv_auth = true\nprovider = Other\nregion = ca-central-1\ntype = s3\n"
+	if string(b) != want {
+		t.Errorf("got configuration %q, want %q", b, want)
+	}
+
+	var out bytes.Buffer
+	if err := WriteProcessCredentials(&out, r.config.credentialsFile()); err != nil {
+		t.Fatalf("WriteProcessCredentials: %v", err)
+	}
+
+	var creds processCredentials
+	if err := json.Unmarshal(out.Bytes(), &creds); err != nil {
+		t.Fatalf("failed to decode credentials: %v", err)
+	}
+	if creds.Version != 1 || creds.AccessKeyID != "access" || creds.SecretAccessKey != "secret" {
+		t.Errorf("got credentials %+v", creds)
+	}
+
+	// The keys expire, so that rotated keys are read again
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil || time.Until(expiration) > rcloneProcessCredentialsTTL {
+		t.Errorf("got expiration %q, want within %v", creds.Expiration, rcloneProcessCredentialsTTL)
+	}
+}
+
+func TestRcloneReload(t *testing.T) {
+	rcloneReloadWait = 0
+	defer func() { rcloneReloadWait = rcloneProcessCredentialsTTL }()
+
+	tests := []struct {
+		remoteType string
+		wantErr    bool
+	}{
+		{remoteType: "s3"},
+		{remoteType: "azureblob", wantErr: true},
+		{remoteType: "sftp", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.remoteType, func(t *testing.T) {
+			r := &rclone{config: Config{Options: map[string]string{"remoteType": tt.remoteType}}}
+
+			err := r.Reload(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRcloneAWSEnv(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "node")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+
+	r := &rclone{config: Config{StatePrefix: "/tmp/data"}}
+	env := strings.Join(r.awsEnv(), "\n")
+
+	if strings.Contains(env, "AWS_ACCESS_KEY_ID") {
+		t.Errorf("keys of the node are passed to rclone")
+	}
+	if !strings.Contains(env, "AWS_CONFIG_FILE=/tmp/data.aws.conf") {
+		t.Errorf("AWS configuration is not set: %s", env)
+	}
+}
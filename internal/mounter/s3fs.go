@@ -0,0 +1,137 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/utils"
+	"k8s.io/klog"
+)
+
+// s3fs mounts S3 buckets using s3fs-fuse.
+type s3fs struct {
+	config Config
+}
+
+func newS3fs(config Config) Mounter {
+	return &s3fs{
+		config: config,
+	}
+}
+
+func (s *s3fs) Validate() error {
+	if _, ok := s.config.Options["bucket"]; !ok {
+		return fmt.Errorf("bucket option is required")
+	}
+
+	if err := s.config.positionalOptions("bucket"); err != nil {
+		return err
+	}
+
+	if err := s.config.numericOptions("uid", "gid"); err != nil {
+		return err
+	}
+
+	if err := s.config.plainOptions(",", "endpoint", "region"); err != nil {
+		return err
+	}
+
+	if _, err := s.config.umask(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// WriteCredentials writes the credentials in the s3fs passwd format.
+// s3fs refuses to read a passwd file which is accessible by others.
+func (s *s3fs) WriteCredentials(creds *agent.IssueCredentialResponse) error {
+	if err := checkAccessKeys(creds); err != nil {
+		return err
+	}
+
+	// The passwd format has no place for a session token
+	if creds.SessionToken != "" {
+		return fmt.Errorf("s3fs does not support session tokens")
+	}
+
+	passwd := fmt.Sprintf("%s:%s\n", creds.AccessKey, creds.SecretKey)
+	return writeFile(s.config.credentialsFile(), []byte(passwd), 0600)
+}
+
+func (s *s3fs) Command(ctx context.Context) (*exec.Cmd, error) {
+	options := s.config.Options
+
+	umask, err := s.config.umask()
+	if err != nil {
+		return nil, err
+	}
+
+	mountOptions := []string{
+		"allow_other",
+		fmt.Sprintf("passwd_file=%s", s.config.credentialsFile()),
+		fmt.Sprintf("umask=%04o", umask),
+		fmt.Sprintf("mp_umask=%04o", umask),
+	}
+
+	// Endpoint (non-AWS endpoints, such as MinIO, require path style requests)
+	if val, ok := options["endpoint"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("url=%s", val), "use_path_request_style")
+	}
+
+	// Region (s3fs calls the region the "endpoint")
+	if val, ok := options["region"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("endpoint=%s", val))
+	}
+
+	// UID
+	if val, ok := options["uid"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("uid=%s", val))
+	}
+
+	// GID
+	if val, ok := options["gid"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("gid=%s", val))
+	}
+
+	// Debug
+	if val, ok := options["debug_s3"]; ok {
+		bval, err := strconv.ParseBool(val)
+		if err != nil {
+			klog.Warningf("failed to parse bool for debug_s3: %s : %v", val, err)
+		}
+		if bval {
+			mountOptions = append(mountOptions, "dbglevel=debug", "curldbg")
+		}
+	}
+
+	s3fsArgs := []string{
+		// Bucket and mount path (positional arguments)
+		options["bucket"],
+		s.config.Target,
+
+		// Run in foreground mode
+		"-f",
+		"-o", strings.Join(mountOptions, ","),
+	}
+
+	return exec.CommandContext(ctx, "s3fs", s3fsArgs...), nil
+}
+
+func (s *s3fs) Ready() (bool, error) {
+	return utils.IsMountPoint(s.config.Target)
+}
+
+func (s *s3fs) Unmount(proc *os.Process) error {
+	// s3fs unmounts the bucket when it is terminated
+	return terminate(proc, s.config.Target)
+}
+
+func (s *s3fs) Cleanup() error {
+	return removeFiles(s.config.credentialsFile())
+}
@@ -0,0 +1,223 @@
+package mounter
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/StatCan/boathouse/internal/agent"
+	"github.com/StatCan/boathouse/internal/utils"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshfs mounts directories from SFTP hosts using sshfs,
+// authenticating with a certificate signed by Vault.
+type sshfs struct {
+	config Config
+
+	// publicKey is the public half of the ephemeral keypair
+	publicKey string
+}
+
+func newSSHFS(config Config) Mounter {
+	return &sshfs{
+		config: config,
+	}
+}
+
+func (s *sshfs) Validate() error {
+	if _, ok := s.config.Options["host"]; !ok {
+		return fmt.Errorf("host option is required")
+	}
+
+	if _, ok := s.config.Options["user"]; !ok {
+		return fmt.Errorf("user option is required")
+	}
+
+	// Host keys are always verified, as the host is not trusted on first use
+	knownHosts, ok := s.config.Options["knownHosts"]
+	if !ok {
+		return fmt.Errorf("knownHosts option, or the backend.sshKnownHosts setting, is required")
+	}
+
+	if err := parseKnownHosts(knownHosts); err != nil {
+		return fmt.Errorf("invalid knownHosts: %v", err)
+	}
+
+	if _, err := s.config.umask(); err != nil {
+		return err
+	}
+
+	if err := s.config.numericOptions("port", "uid", "gid"); err != nil {
+		return err
+	}
+
+	// user, host and path form the remote, which must not add
+	// options to sshfs or ssh (e.g., ProxyCommand)
+	if err := s.config.plainOptions(",=", "user", "host", "path"); err != nil {
+		return err
+	}
+
+	return s.config.positionalOptions("user", "host", "path")
+}
+
+// PrepareRequest generates the ephemeral keypair for the mount,
+// and requests that its public key be signed.
+func (s *sshfs) PrepareRequest(req *agent.IssueCredentialRequest) error {
+	if s.publicKey == "" {
+		if err := s.generateKey(); err != nil {
+			return err
+		}
+	}
+
+	req.PublicKey = s.publicKey
+	req.ValidPrincipals = s.config.Options["user"]
+	return nil
+}
+
+// WriteCredentials writes the signed certificate, which ssh
+// reads whenever sshfs reconnects.
+func (s *sshfs) WriteCredentials(creds *agent.IssueCredentialResponse) error {
+	if creds.Certificate == "" {
+		return fmt.Errorf("no certificate was issued")
+	}
+
+	return writeFile(s.certificateFile(), []byte(creds.Certificate), 0600)
+}
+
+func (s *sshfs) Command(ctx context.Context) (*exec.Cmd, error) {
+	options := s.config.Options
+
+	umask, err := s.config.umask()
+	if err != nil {
+		return nil, err
+	}
+
+	mountOptions := []string{
+		"allow_other",
+		"reconnect",
+		"ServerAliveInterval=15",
+		"BatchMode=yes",
+		fmt.Sprintf("IdentityFile=%s", s.keyFile()),
+		fmt.Sprintf("CertificateFile=%s", s.certificateFile()),
+		fmt.Sprintf("umask=%04o", umask),
+	}
+
+	// Host key verification
+	if err := writeFile(s.knownHostsFile(), []byte(options["knownHosts"]+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	mountOptions = append(mountOptions,
+		fmt.Sprintf("UserKnownHostsFile=%s", s.knownHostsFile()),
+		"StrictHostKeyChecking=yes",
+	)
+
+	// Port
+	if val, ok := options["port"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("port=%s", val))
+	}
+
+	// UID
+	if val, ok := options["uid"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("uid=%s", val))
+	}
+
+	// GID
+	if val, ok := options["gid"]; ok {
+		mountOptions = append(mountOptions, fmt.Sprintf("gid=%s", val))
+	}
+
+	sshfsArgs := []string{
+		// Run in foreground mode
+		"-f",
+		"-o", strings.Join(mountOptions, ","),
+
+		// Remote and mount path (positional arguments)
+		"--",
+		fmt.Sprintf("%s@%s:%s", options["user"], options["host"], s.config.option("path", "")),
+		s.config.Target,
+	}
+
+	return exec.CommandContext(ctx, "sshfs", sshfsArgs...), nil
+}
+
+func (s *sshfs) Ready() (bool, error) {
+	return utils.IsMountPoint(s.config.Target)
+}
+
+func (s *sshfs) Unmount(proc *os.Process) error {
+	// sshfs unmounts the directory when it is terminated
+	return terminate(proc, s.config.Target)
+}
+
+func (s *sshfs) Cleanup() error {
+	return removeFiles(s.keyFile(), s.certificateFile(), s.knownHostsFile())
+}
+
+// parseKnownHosts checks that knownHosts has at least one host key,
+// and that each of its lines is a valid known_hosts entry.
+func parseKnownHosts(knownHosts string) error {
+	rest := []byte(knownHosts)
+	for entries := 0; ; entries++ {
+		var err error
+		_, _, _, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			if entries == 0 {
+				return fmt.Errorf("no host keys")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// generateKey generates the ephemeral keypair and writes the private key.
+func (s *sshfs) generateKey() error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	pub, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	pemKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: der,
+	})
+	if err = writeFile(s.keyFile(), pemKey, 0600); err != nil {
+		return err
+	}
+
+	s.publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	return nil
+}
+
+func (s *sshfs) keyFile() string {
+	return fmt.Sprintf("%s.ssh.key", s.config.StatePrefix)
+}
+
+func (s *sshfs) certificateFile() string {
+	return fmt.Sprintf("%s.ssh.key-cert.pub", s.config.StatePrefix)
+}
+
+func (s *sshfs) knownHostsFile() string {
+	return fmt.Sprintf("%s.ssh.known_hosts", s.config.StatePrefix)
+}
@@ -0,0 +1,87 @@
+package mounter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testHostKey = "sftp.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOEHQzXIdQsJX7v2F3BMrwHFkCVGzE8TMU5IpEP9Njb8"
+	testHostCA  = "@cert-authority *.example.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOEHQzXIdQsJX7v2F3BMrwHFkCVGzE8TMU5IpEP9Njb8"
+)
+
+func TestSSHFSValidate(t *testing.T) {
+	options := func(overrides map[string]string) map[string]string {
+		opts := map[string]string{"host": "sftp.example.com", "user": "team-a", "knownHosts": testHostKey}
+		for key, val := range overrides {
+			opts[key] = val
+		}
+		for key, val := range opts {
+			if val == "" {
+				delete(opts, key)
+			}
+		}
+		return opts
+	}
+
+	tests := []struct {
+		name    string
+		options map[string]string
+		wantErr bool
+	}{
+		{name: "valid", options: options(nil)},
+		{name: "host CA", options: options(map[string]string{"knownHosts": testHostCA})},
+		{name: "missing knownHosts", options: options(map[string]string{"knownHosts": ""}), wantErr: true},
+		{name: "invalid knownHosts", options: options(map[string]string{"knownHosts": "sftp.example.com not-a-key"}), wantErr: true},
+		{name: "option in port", options: options(map[string]string{"port": "22,ProxyCommand=id"}), wantErr: true},
+		{name: "option in host", options: options(map[string]string{"host": "sftp.example.com,ProxyCommand=id"}), wantErr: true},
+		{name: "flag as user", options: options(map[string]string{"user": "-oProxyCommand"}), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSSHFS(Config{Target: "/mnt/sftp", Options: tt.options, StatePrefix: "/tmp/sftp"})
+
+			err := s.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSSHFSCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sshfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &sshfs{config: Config{
+		Target:      "/mnt/sftp",
+		Options:     map[string]string{"host": "sftp.example.com", "user": "team-a", "knownHosts": testHostCA},
+		StatePrefix: filepath.Join(dir, "sftp"),
+	}}
+
+	cmd, err := s.Command(context.Background())
+	if err != nil {
+		t.Fatalf("Command: %v", err)
+	}
+
+	args := strings.Join(cmd.Args, " ")
+	if !strings.Contains(args, "StrictHostKeyChecking=yes") {
+		t.Errorf("host keys are not verified: %s", args)
+	}
+	if !strings.HasSuffix(args, "-- team-a@sftp.example.com: /mnt/sftp") {
+		t.Errorf("got arguments %s, want the remote after --", args)
+	}
+
+	b, err := ioutil.ReadFile(s.knownHostsFile())
+	if err != nil || string(b) != testHostCA+"\n" {
+		t.Errorf("got known hosts %q, %v", b, err)
+	}
+}
@@ -0,0 +1,59 @@
+package mounter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/StatCan/boathouse/internal/agent"
+)
+
+// State is the state of a mount, persisted so that
+// later calls to the driver can act on the mount.
+type State struct {
+	// Backend is the name of the backend which performed the mount.
+	Backend string `json:"backend"`
+
+	// LeaseID is the lease of the credentials held by the mount.
+	LeaseID string `json:"lease_id,omitempty"`
+
+	// LeaseTarget is the Vault target which issued the lease.
+	LeaseTarget string `json:"lease_target,omitempty"`
+
+	// Pod is the pod the lease was issued to, which revokes it.
+	Pod agent.PodIdentity `json:"pod,omitempty"`
+}
+
+// SaveState writes the state of the mount with the given state prefix.
+func SaveState(statePrefix string, state State) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(stateFile(statePrefix), b, 0600)
+}
+
+// LoadState reads the state of the mount with the given state prefix.
+func LoadState(statePrefix string) (*State, error) {
+	b, err := ioutil.ReadFile(stateFile(statePrefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err = json.Unmarshal(b, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// RemoveState removes the state of the mount with the given state prefix.
+func RemoveState(statePrefix string) error {
+	return removeFiles(stateFile(statePrefix))
+}
+
+func stateFile(statePrefix string) string {
+	return fmt.Sprintf("%s.state", statePrefix)
+}
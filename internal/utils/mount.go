@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// IsMountPoint reports whether path is the root of a mounted filesystem.
+func IsMountPoint(path string) (bool, error) {
+	var st, parent syscall.Stat_t
+
+	if err := syscall.Stat(path, &st); err != nil {
+		return false, err
+	}
+
+	if err := syscall.Stat(filepath.Dir(filepath.Clean(path)), &parent); err != nil {
+		return false, err
+	}
+
+	return st.Dev != parent.Dev, nil
+}
+
+// Unmount unmounts the filesystem mounted at path.
+func Unmount(path string) error {
+	return syscall.Unmount(path, 0)
+}
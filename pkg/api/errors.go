@@ -0,0 +1,64 @@
+package api
+
+import "errors"
+
+// Reasons for failed requests.
+const (
+	ReasonBadRequest       = "BadRequest"
+	ReasonPermissionDenied = "PermissionDenied"
+	ReasonNotFound         = "NotFound"
+	ReasonMethodNotAllowed = "MethodNotAllowed"
+	ReasonNotRenewable     = "NotRenewable"
+	ReasonTooLarge         = "RequestTooLarge"
+	ReasonRateLimited      = "RateLimited"
+	ReasonUnavailable      = "Unavailable"
+	ReasonVaultError       = "VaultError"
+	ReasonInternal         = "InternalError"
+)
+
+// StatusFailure is the status of every Error. Errors are failed
+// flexvolume DriverStatuses, whose message may be passed on to the kubelet.
+const StatusFailure = "Failure"
+
+// Error is a failed request.
+type Error struct {
+	Status  string `json:"status"`
+	Code    int    `json:"code"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ReasonForError returns the reason of a failed request,
+// or an empty string if err is not an *Error.
+func ReasonForError(err error) string {
+	var aerr *Error
+	if errors.As(err, &aerr) {
+		return aerr.Reason
+	}
+
+	return ""
+}
+
+// IsNotFound reports whether the request failed as Vault has no such path.
+func IsNotFound(err error) bool {
+	return ReasonForError(err) == ReasonNotFound
+}
+
+// IsPermissionDenied reports whether the request was refused by the agent's policy or Vault.
+func IsPermissionDenied(err error) bool {
+	return ReasonForError(err) == ReasonPermissionDenied
+}
+
+// IsNotRenewable reports whether the request failed as the lease can no longer be renewed.
+func IsNotRenewable(err error) bool {
+	return ReasonForError(err) == ReasonNotRenewable
+}
+
+// IsUnavailable reports whether the request failed as Vault is sealed or unreachable.
+func IsUnavailable(err error) bool {
+	return ReasonForError(err) == ReasonUnavailable
+}
@@ -0,0 +1,182 @@
+// Package api defines the version 1 API of the boathouse agent,
+// served over its unix socket, and described in docs/openapi.yaml.
+package api
+
+import "time"
+
+// Routes of the version 1 API.
+const (
+	PathIssue   = "/v1/issue"
+	PathRenew   = "/v1/renew"
+	PathRevoke  = "/v1/revoke"
+	PathLeases  = "/v1/leases"
+	PathToken   = "/v1/token"
+	PathHealthz = "/v1/healthz"
+	PathReadyz  = "/v1/readyz"
+)
+
+// MaxRequestBytes is the largest request body accepted by the agent.
+const MaxRequestBytes = 64 << 10
+
+// IssueCredentialRequest represents a request for credentials.
+type IssueCredentialRequest struct {
+	// Path is the Vault path
+	Path string `json:"path"`
+
+	// TTL is the requested time
+	TTL time.Duration `json:"ttl"`
+
+	// PublicKey is an SSH public key to be signed by the
+	// Vault SSH secrets engine, instead of issuing credentials
+	PublicKey string `json:"public_key,omitempty"`
+
+	// ValidPrincipals are the principals requested for the signed key
+	ValidPrincipals string `json:"valid_principals,omitempty"`
+
+	// Engine is the type of secrets engine at Path, when it is
+	// not a dynamic secrets engine. (e.g., kv-v2)
+	Engine string `json:"engine,omitempty"`
+
+	// Version pins the version of a static secret
+	Version int `json:"version,omitempty"`
+
+	// Target is the name of the provider, or Vault target, to request
+	// credentials from. By default, it is selected by the prefix of Path.
+	Target string `json:"target,omitempty"`
+
+	PodIdentity
+}
+
+// PodIdentity identifies the pod making a request, as provided by the kubelet.
+type PodIdentity struct {
+	Namespace      string `json:"namespace,omitempty"`
+	PodName        string `json:"pod_name,omitempty"`
+	PodUID         string `json:"pod_uid,omitempty"`
+	ServiceAccount string `json:"service_account,omitempty"`
+
+	// MountPath is the directory the volume is mounted at. With PodUID,
+	// it identifies the mount holding a lease among those sharing it.
+	MountPath string `json:"mount_path,omitempty"`
+}
+
+type Lease struct {
+	ID     string    `json:"id"`
+	Expiry time.Time `json:"expiry"`
+
+	// Renewable is set if the lease can be renewed
+	Renewable bool `json:"renewable,omitempty"`
+
+	// Static is set for synthetic leases of static secrets,
+	// which are re-read on expiry rather than renewed
+	Static bool `json:"static,omitempty"`
+
+	// Target is the name of the provider, or Vault target, that issued the lease
+	Target string `json:"target,omitempty"`
+}
+
+type IssueCredentialResponse struct {
+	Lease     Lease  `json:"lease"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+
+	// Version is the version of a static secret
+	Version int `json:"version,omitempty"`
+
+	// SessionToken accompanies temporary (STS) credentials
+	SessionToken string `json:"session_token,omitempty"`
+
+	// Azure storage account credentials (account key or SAS token)
+	AccountName string `json:"account_name,omitempty"`
+	AccountKey  string `json:"account_key,omitempty"`
+	SASToken    string `json:"sas_token,omitempty"`
+
+	// Azure service principal credentials (Vault Azure secrets engine)
+	ClientID     string `json:"client_id,omitempty"`
+	ClientSecret string `json:"client_secret,omitempty"`
+
+	// Directory credentials (Vault AD and LDAP secrets engines)
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Certificate is a signed SSH certificate (Vault SSH secrets engine)
+	Certificate string `json:"certificate,omitempty"`
+}
+
+// RenewLeaseRequest represents a request to renew a lease.
+type RenewLeaseRequest struct {
+	// LeaseID is the ID of the lease to renew
+	LeaseID string `json:"lease_id"`
+
+	// Increment is the requested extension of the lease
+	Increment time.Duration `json:"increment"`
+
+	// Target is the name of the provider, or Vault target, that issued the lease
+	Target string `json:"target,omitempty"`
+
+	PodIdentity
+}
+
+type RenewLeaseResponse struct {
+	Lease Lease `json:"lease"`
+}
+
+// RevokeLeaseRequest represents a request to revoke a lease.
+type RevokeLeaseRequest struct {
+	// LeaseID is the ID of the lease to revoke
+	LeaseID string `json:"lease_id"`
+
+	// Target is the name of the provider, or Vault target, that issued the lease
+	Target string `json:"target,omitempty"`
+
+	PodIdentity
+}
+
+// HeldLease is a lease held by mounts on the node.
+type HeldLease struct {
+	ID     string    `json:"id"`
+	Expiry time.Time `json:"expiry"`
+
+	// Target is the name of the provider, or Vault target, that issued the lease
+	Target string `json:"target,omitempty"`
+
+	// Path is the path the lease was issued for
+	Path string `json:"path,omitempty"`
+
+	// Holders is the number of mounts sharing the lease
+	Holders int `json:"holders"`
+}
+
+// LeaseList lists the leases held by mounts on the node.
+type LeaseList struct {
+	Leases []HeldLease `json:"leases"`
+}
+
+// TokenHealth reports the state of the agent's Vault token.
+type TokenHealth struct {
+	Target      string    `json:"target"`
+	Method      string    `json:"method"`
+	Valid       bool      `json:"valid"`
+	Expiry      time.Time `json:"expiry,omitempty"`
+	Renewable   bool      `json:"renewable"`
+	LastRenewal time.Time `json:"last_renewal,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Health statuses.
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// HealthCheck is the result of one of the checks of the agent's readiness.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// Health reports the health, or readiness, of the agent.
+type Health struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
@@ -0,0 +1,248 @@
+// Package client is a client of the version 1 API of the boathouse agent.
+//
+// Failed requests return an *api.Error, whose reason may be checked
+// with the helpers of the api package. (e.g., api.IsNotRenewable)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/StatCan/boathouse/pkg/api"
+)
+
+// DefaultTimeout bounds requests whose context has no deadline.
+const DefaultTimeout = 1 * time.Minute
+
+// Client is a boathouse client.
+type Client struct {
+	// Timeout bounds requests whose context has no deadline.
+	// Zero leaves them unbounded.
+	Timeout time.Duration
+
+	http *http.Client
+}
+
+// NewClient generates a new client of the agent listening on sock.
+func NewClient(sock *net.UnixAddr) (*Client, error) {
+	if sock == nil || sock.Name == "" {
+		return nil, fmt.Errorf("agent socket is required")
+	}
+
+	dialer := &net.Dialer{}
+	return &Client{
+		Timeout: DefaultTimeout,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", sock.Name)
+				},
+			},
+		},
+	}, nil
+}
+
+// IssueCredentials asks the agent for credentials.
+func (c *Client) IssueCredentials(ctx context.Context, req api.IssueCredentialRequest) (*api.IssueCredentialResponse, error) {
+	var creds api.IssueCredentialResponse
+	if err := c.do(ctx, http.MethodPost, api.PathIssue, req, &creds); err != nil {
+		return nil, err
+	}
+
+	return &creds, nil
+}
+
+// RenewLease asks the agent to renew a lease. An error with
+// the reason api.ReasonNotRenewable is returned if the lease
+// can no longer be renewed.
+func (c *Client) RenewLease(ctx context.Context, req api.RenewLeaseRequest) (*api.RenewLeaseResponse, error) {
+	var lease api.RenewLeaseResponse
+	if err := c.do(ctx, http.MethodPost, api.PathRenew, req, &lease); err != nil {
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+// RevokeLease asks the agent to revoke a lease.
+func (c *Client) RevokeLease(ctx context.Context, req api.RevokeLeaseRequest) error {
+	return c.do(ctx, http.MethodPost, api.PathRevoke, req, nil)
+}
+
+// ListLeases lists the leases held by mounts on the node.
+func (c *Client) ListLeases(ctx context.Context) ([]api.HeldLease, error) {
+	var list api.LeaseList
+	if err := c.do(ctx, http.MethodGet, api.PathLeases, nil, &list); err != nil {
+		return nil, err
+	}
+
+	return list.Leases, nil
+}
+
+// TokenHealth reports the state of the agent's Vault tokens.
+// An error is returned, along with their state, if any is invalid.
+func (c *Client) TokenHealth(ctx context.Context) ([]api.TokenHealth, error) {
+	var health []api.TokenHealth
+	code, err := c.get(ctx, api.PathToken, &health)
+	if err != nil {
+		return nil, err
+	}
+
+	if code != http.StatusOK {
+		return health, unexpectedStatus(code, "agent has invalid vault tokens")
+	}
+
+	return health, nil
+}
+
+// Healthz queries the health of the agent.
+// An error is returned, along with the health, if the agent is not healthy.
+func (c *Client) Healthz(ctx context.Context) (*api.Health, error) {
+	return c.health(ctx, api.PathHealthz)
+}
+
+// Readyz queries the readiness of the agent to issue credentials.
+// An error is returned, along with the health, if the agent is not ready.
+func (c *Client) Readyz(ctx context.Context) (*api.Health, error) {
+	return c.health(ctx, api.PathReadyz)
+}
+
+func (c *Client) health(ctx context.Context, path string) (*api.Health, error) {
+	var health api.Health
+	code, err := c.get(ctx, path, &health)
+	if err != nil {
+		return nil, err
+	}
+
+	if code != http.StatusOK {
+		return &health, unexpectedStatus(code, fmt.Sprintf("agent is %s", health.Status))
+	}
+
+	return &health, nil
+}
+
+// get makes a request whose response is decoded into resp,
+// even on failure, and returns its status code.
+func (c *Client) get(ctx context.Context, path string, resp interface{}) (int, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://boathouse"+path, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	hresp, err := c.http.Do(hreq)
+	if err != nil {
+		return 0, err
+	}
+	defer hresp.Body.Close()
+
+	if err := json.NewDecoder(hresp.Body).Decode(resp); err != nil {
+		return 0, unexpectedStatus(hresp.StatusCode, "invalid response")
+	}
+
+	return hresp.StatusCode, nil
+}
+
+// do makes a JSON request to the agent over the unix socket,
+// decoding the response into resp unless it is nil.
+func (c *Client) do(ctx context.Context, method, path string, req, resp interface{}) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var body io.Reader
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, method, "http://boathouse"+path, body)
+	if err != nil {
+		return err
+	}
+
+	if req != nil {
+		hreq.Header.Set("Content-Type", "application/json")
+	}
+
+	hresp, err := c.http.Do(hreq)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+
+	if hresp.StatusCode != http.StatusOK {
+		return decodeError(hresp)
+	}
+
+	if resp == nil {
+		return nil
+	}
+
+	b, err := ioutil.ReadAll(hresp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, resp)
+}
+
+// withTimeout applies the client's timeout to ctx, unless it has a deadline.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.Timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, c.Timeout)
+}
+
+// decodeError returns the error reported by the agent in a failed response.
+func decodeError(hresp *http.Response) error {
+	var aerr api.Error
+	if err := json.NewDecoder(hresp.Body).Decode(&aerr); err != nil || aerr.Message == "" {
+		return unexpectedStatus(hresp.StatusCode, "unexpected response")
+	}
+
+	if aerr.Code == 0 {
+		aerr.Code = hresp.StatusCode
+	}
+
+	return &aerr
+}
+
+// statusReasons are the reasons of failed responses without an error.
+var statusReasons = map[int]string{
+	http.StatusBadRequest:            api.ReasonBadRequest,
+	http.StatusForbidden:             api.ReasonPermissionDenied,
+	http.StatusNotFound:              api.ReasonNotFound,
+	http.StatusMethodNotAllowed:      api.ReasonMethodNotAllowed,
+	http.StatusConflict:              api.ReasonNotRenewable,
+	http.StatusRequestEntityTooLarge: api.ReasonTooLarge,
+	http.StatusTooManyRequests:       api.ReasonRateLimited,
+	http.StatusServiceUnavailable:    api.ReasonUnavailable,
+}
+
+func unexpectedStatus(code int, message string) *api.Error {
+	reason, ok := statusReasons[code]
+	if !ok {
+		reason = api.ReasonInternal
+	}
+
+	return &api.Error{
+		Status:  api.StatusFailure,
+		Code:    code,
+		Reason:  reason,
+		Message: fmt.Sprintf("%s (status code %d)", message, code),
+	}
+}